@@ -92,3 +92,23 @@ func (mr *MockSecretCreatorMockRecorder) CreateSecret(arg0, arg1 interface{}, ar
 	varargs := append([]interface{}{arg0, arg1}, arg2...)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSecret", reflect.TypeOf((*MockSecretCreator)(nil).CreateSecret), varargs...)
 }
+
+// PutSecretValue mocks base method
+func (m *MockSecretCreator) PutSecretValue(arg0 context.Context, arg1 *secretsmanager.PutSecretValueInput, arg2 ...func(*secretsmanager.Options)) (*secretsmanager.PutSecretValueOutput, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "PutSecretValue", varargs...)
+	ret0, _ := ret[0].(*secretsmanager.PutSecretValueOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PutSecretValue indicates an expected call of PutSecretValue
+func (mr *MockSecretCreatorMockRecorder) PutSecretValue(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutSecretValue", reflect.TypeOf((*MockSecretCreator)(nil).PutSecretValue), varargs...)
+}
@@ -2,20 +2,32 @@ package create
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"html"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
 	"github.com/pkg/browser"
 	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/rclark/aws-basics/github-app/secrets"
+	"github.com/rclark/aws-basics/github-app/tokens/invocation"
 )
 
 //go:generate mockgen -source ./server.go -package mock -destination ./mock/server.go
@@ -26,20 +38,88 @@ type Requester interface {
 	Do(*http.Request) (*http.Response, error)
 }
 
-// SecretCreator implements a method for saving secrets in AWS SecretsManager.
+// SecretCreator implements methods for saving secrets in AWS SecretsManager,
+// either by creating them, or by updating secrets that already exist.
 type SecretCreator interface {
 	CreateSecret(context.Context, *secretsmanager.CreateSecretInput, ...func(*secretsmanager.Options)) (*secretsmanager.CreateSecretOutput, error)
+	PutSecretValue(context.Context, *secretsmanager.PutSecretValueInput, ...func(*secretsmanager.Options)) (*secretsmanager.PutSecretValueOutput, error)
+}
+
+// DefaultBaseURL is the root of GitHub's REST API, used when a LocalhostServer
+// does not set BaseURL.
+const DefaultBaseURL = "https://api.github.com"
+
+// DefaultWebURL is the root of GitHub's website, used when a LocalhostServer
+// does not set WebURL.
+const DefaultWebURL = "https://github.com"
+
+// Manifest describes the GitHub App to create. See https://docs.github.com/en/developers/apps/building-github-apps/creating-a-github-app-from-a-manifest#github-app-manifest-parameters
+// for more information about each parameter.
+type Manifest struct {
+	Name        string            `json:"name"`
+	URL         string            `json:"url"`
+	Public      bool              `json:"public"`
+	Permissions map[string]string `json:"default_permissions"`
+	Events      []string          `json:"default_events"`
+}
+
+// DefaultManifest describes the aws-basics GitHub App, which can read the
+// contents of repositories, and receives push events.
+func DefaultManifest() *Manifest {
+	return &Manifest{
+		Name:   "aws-basics",
+		URL:    "https://github.com/rclark/aws-basics",
+		Public: false,
+		Permissions: map[string]string{
+			"contents": "read",
+		},
+		Events: []string{"push"},
+	}
 }
 
 // LocalhostServer runs a localhost website that helps automate the creation of
 // a new GitHub App.
 type LocalhostServer struct {
 	http.Server
-	Secrets   SecretCreator
+	Secrets SecretCreator
+
+	// BaseURL is the root of the GitHub REST API that converts the app's
+	// manifest into credentials. GitHub Enterprise Server installations use a
+	// URL like https://HOST/api/v3. Defaults to DefaultBaseURL.
+	BaseURL string
+
+	// WebURL is the root of the GitHub website where the app is created.
+	// Defaults to DefaultWebURL.
+	WebURL string
+
+	// Manifest describes the GitHub App to create. Defaults to
+	// DefaultManifest().
+	Manifest *Manifest
+
+	// TFVarsPath optionally names a file where the new app's non-secret values
+	// are written as Terraform variables.
+	TFVarsPath string
+
 	requester Requester
 	open      func(string) error
 	done      chan bool
 	errors    chan error
+
+	// interrupt receives the signals that cancel the flow. When nil, CreateApp
+	// listens for SIGINT and SIGTERM.
+	interrupt chan os.Signal
+
+	// state is a random value that is sent to GitHub when the page is rendered.
+	// GitHub returns it in the redirect, which proves that the redirect resulted
+	// from this server's page.
+	state string
+	mu    sync.Mutex
+
+	// running is cancelled when CreateApp returns, and bounds the wait for the
+	// app to be installed.
+	running  context.Context
+	awaiting bool
+	poll     time.Duration
 }
 
 // NewLocalhostServer sets up the localhost website.
@@ -67,9 +147,29 @@ func NewLocalhostServer(sm SecretCreator) *LocalhostServer {
 // CreateApp launches the localhost website, and opens it in the user's default
 // web browser. The user is expected to submit the form, which will redirect to
 // GitHub in order to create the aws-basics GitHub App. After the user has
-// finished, the system receives the new GitHub App's credentials. The site
-// prompts the user to record those credentials in their config.tfvars file.
+// finished, the system receives the new GitHub App's credentials, and stores
+// them in AWS SecretsManager. If the server has a TFVarsPath, the app's id,
+// client id, and slug are also written to that file, so that Terraform can
+// reference them.
+//
+// The id of the app's first installation is stored as well. A new app usually
+// has not been installed yet, so the site asks the user to install it, and the
+// system waits for the installation to appear until the context expires.
+//
+// If the user interrupts the process, the website is shut down and the flow
+// is cancelled.
 func (l *LocalhostServer) CreateApp(ctx context.Context) (err error) {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	l.running = ctx
+
+	interrupt := l.interrupt
+	if interrupt == nil {
+		interrupt = make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(interrupt)
+	}
+
 	go l.listen()
 	time.Sleep(5 * time.Millisecond)
 
@@ -83,8 +183,13 @@ func (l *LocalhostServer) CreateApp(ctx context.Context) (err error) {
 		err = nil
 	case <-ctx.Done():
 		err = errors.New("timed out attempting to create GitHub app")
+		if l.awaitingInstallation() {
+			err = errors.Errorf("timed out waiting for the app to be installed. Its credentials are saved, so once it is installed, run this tool again, or store its installation id in the %s secret", secrets.InstallationID)
+		}
 	case lisErr := <-l.errors:
 		err = lisErr
+	case <-interrupt:
+		err = errors.New("cancelled by user")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -94,8 +199,84 @@ func (l *LocalhostServer) CreateApp(ctx context.Context) (err error) {
 	return err
 }
 
+func (l *LocalhostServer) baseURL() string {
+	if l.BaseURL != "" {
+		return strings.TrimSuffix(l.BaseURL, "/")
+	}
+
+	return DefaultBaseURL
+}
+
+func (l *LocalhostServer) webURL() string {
+	if l.WebURL != "" {
+		return strings.TrimSuffix(l.WebURL, "/")
+	}
+
+	return DefaultWebURL
+}
+
+func (l *LocalhostServer) manifest() *Manifest {
+	if l.Manifest != nil {
+		return l.Manifest
+	}
+
+	return DefaultManifest()
+}
+
+// currentState provides the state that the redirect is expected to provide.
+// It is generated the first time the page is rendered, and reused afterwards,
+// so that reloading the page does not invalidate a form that was already sent.
+func (l *LocalhostServer) currentState() (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.state != "" {
+		return l.state, nil
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate state")
+	}
+	l.state = hex.EncodeToString(b)
+
+	return l.state, nil
+}
+
+// validState reports whether the redirect provided the state that was sent to
+// GitHub.
+func (l *LocalhostServer) validState(state string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.state != "" && subtle.ConstantTimeCompare([]byte(l.state), []byte(state)) == 1
+}
+
 func (l *LocalhostServer) prompt(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprint(w, `
+	// The mux routes every unmatched path here, including the browser's request
+	// for /favicon.ico.
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	state, err := l.currentState()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	manifest := l.manifest()
+	data, err := json.Marshal(struct {
+		*Manifest
+		RedirectURL string `json:"redirect_url"`
+	}{manifest, "http://localhost:6060/redirect/"})
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "failed to serialize manifest").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprintf(w, `
 	<html>
 		<head>
 			<link rel="stylesheet" href="https://unpkg.com/purecss@2.0.6/build/pure-min.css" integrity="sha384-Uu6IeWbM+gzNVXJcM9XV3SohHtmWE+3VGi496jvgX1jyvDTXfdK+rfZc8C1Aehk5" crossorigin="anonymous">
@@ -106,13 +287,13 @@ func (l *LocalhostServer) prompt(w http.ResponseWriter, r *http.Request) {
 			<div class="pure-u-1-2">
 				<br>
 				<form class="pure-form pure-form-aligned">
-					<legend>Create the aws-basics GitHub App</legend>
+					<legend>Create the %s GitHub App</legend>
 					<div class="pure-control-group">
 						<label for="webhook">Your webhook URL</label>
 						<input class="pure-input-2-3" type="text" id="webhook" placeholder="https://xxxxxxxxxx.execute-api.us-west-2.amazonaws.com">
 					</div>
 				</form>
-				<form class="pure-form" action="https://github.com/settings/apps/new" method="post">
+				<form class="pure-form" action="%s/settings/apps/new?state=%s" method="post">
 					<input type="text" name="manifest" id="manifest" hidden><br>
 					<input class="pure-button pure-button-primary" type="submit" value="Go">
 				</form>
@@ -123,27 +304,17 @@ func (l *LocalhostServer) prompt(w http.ResponseWriter, r *http.Request) {
 		<script>
 			const input = document.getElementById("manifest")
 			const webhook = document.getElementById("webhook")
+			const manifest = %s
 
 			webhook.addEventListener('input', (e) => {
-				input.value = JSON.stringify({
-					"name": "aws-basics",
-					"url": "https://github.com/rclark/aws-basics",
-					"redirect_url": "http://localhost:6060/redirect/",
-					"hook_attributes": {
-						"url": e.target.value
-					},
-					"public": false,
-					"default_permissions": {
-						"contents": "read"
-					},
-					"default_events": [
-						"push"
-					]
-				})
+				manifest.hook_attributes = {
+					"url": e.target.value
+				}
+				input.value = JSON.stringify(manifest)
 			})
 		</script>
 	</html>
-	`)
+	`, html.EscapeString(manifest.Name), l.webURL(), state, data)
 }
 
 type response struct {
@@ -156,67 +327,115 @@ type response struct {
 	PEM           string `json:"pem"`
 }
 
+// save creates a secret, or updates its value if the secret already exists.
+func save(ctx context.Context, sm SecretCreator, name, description, value string) error {
+	_, err := sm.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
+		Name:         aws.String(name),
+		Description:  aws.String(description),
+		SecretString: aws.String(value),
+	})
+
+	var exists *types.ResourceExistsException
+	if errors.As(err, &exists) {
+		_, err = sm.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+			SecretId:     aws.String(name),
+			SecretString: aws.String(value),
+		})
+	}
+
+	return err
+}
+
+// Save stores the app's credentials in AWS SecretsManager. Secrets left behind
+// by a previously created app are overwritten.
 func (r response) Save(ctx context.Context, sm SecretCreator) error {
 	g := new(errgroup.Group)
 	g.Go(func() error {
-		_, err := sm.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
-			Name:         aws.String(secrets.AppID),
-			Description:  aws.String("The app's id"),
-			SecretString: aws.String(fmt.Sprint(r.ID)),
-		})
+		err := save(ctx, sm, secrets.AppID, "The app's id", fmt.Sprint(r.ID))
 		return errors.Wrap(err, "failed writing app id to secrets manager")
 	})
 
 	g.Go(func() error {
-		_, err := sm.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
-			Name:         aws.String(secrets.ClientID),
-			Description:  aws.String("The app's client id"),
-			SecretString: aws.String(r.ClientID),
-		})
+		err := save(ctx, sm, secrets.ClientID, "The app's client id", r.ClientID)
 		return errors.Wrap(err, "failed writing client id to secrets manager")
 	})
 
 	g.Go(func() error {
-		_, err := sm.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
-			Name:         aws.String(secrets.ClientSecret),
-			Description:  aws.String("The app's client secret"),
-			SecretString: aws.String(r.ClientSecret),
-		})
+		err := save(ctx, sm, secrets.ClientSecret, "The app's client secret", r.ClientSecret)
 		return errors.Wrap(err, "failed writing client secret to secrets manager")
 	})
 
 	g.Go(func() error {
-		_, err := sm.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
-			Name:         aws.String(secrets.WebhookSecret),
-			Description:  aws.String("The app's webhook secret"),
-			SecretString: aws.String(r.WebhookSecret),
-		})
+		err := save(ctx, sm, secrets.WebhookSecret, "The app's webhook secret", r.WebhookSecret)
 		return errors.Wrap(err, "failed writing webhook secret to secrets manager")
 	})
 
 	g.Go(func() error {
-		_, err := sm.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
-			Name:         aws.String(secrets.PEM),
-			Description:  aws.String("The app's pem"),
-			SecretString: aws.String(r.PEM),
-		})
+		err := save(ctx, sm, secrets.PEM, "The app's pem", r.PEM)
 		return errors.Wrap(err, "failed writing pem to secrets manager")
 	})
 
 	g.Go(func() error {
-		_, err := sm.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
-			Name:         aws.String(secrets.Token),
-			Description:  aws.String("The app's token"),
-			SecretString: aws.String("null"),
-		})
+		err := save(ctx, sm, secrets.Token, "The app's token", "null")
 		return errors.Wrap(err, "failed to create token in secrets manager")
 	})
 
 	return g.Wait()
 }
 
+// WriteTFVars writes the app's non-secret values to a file as Terraform
+// variable assignments. Secret values are only stored in AWS SecretsManager. If
+// the file already exists, its other contents are preserved: assignments to the
+// app's variables are replaced, and missing ones are appended.
+func (r response) WriteTFVars(path string) error {
+	vars := []struct {
+		name  string
+		value string
+	}{
+		{"github-app-id", fmt.Sprint(r.ID)},
+		{"github-app-client-id", r.ClientID},
+		{"github-app-slug", r.Slug},
+	}
+
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to read tfvars file")
+	}
+
+	var lines []string
+	if trimmed := strings.TrimRight(string(existing), "\n"); trimmed != "" {
+		lines = strings.Split(trimmed, "\n")
+	}
+
+	for _, v := range vars {
+		assignment := fmt.Sprintf("%-20s = %s", v.name, strconv.Quote(v.value))
+		pattern := regexp.MustCompile(`^\s*` + regexp.QuoteMeta(v.name) + `\s*=`)
+
+		replaced := false
+		for i, line := range lines {
+			if pattern.MatchString(line) {
+				lines[i] = assignment
+				replaced = true
+			}
+		}
+
+		if !replaced {
+			lines = append(lines, assignment)
+		}
+	}
+
+	data := []byte(strings.Join(lines, "\n") + "\n")
+	return errors.Wrap(os.WriteFile(path, data, 0644), "failed to write tfvars file")
+}
+
 func (l *LocalhostServer) accept(w http.ResponseWriter, r *http.Request) {
 	values := r.URL.Query()
+	if !l.validState(values.Get("state")) {
+		fmt.Fprintf(w, "Failed! For more details, see your terminal. You can close this browser window.")
+		l.errors <- errors.New("state in response does not match the state sent to GitHub")
+		return
+	}
+
 	code := values.Get("code")
 	if code == "" {
 		l.errors <- errors.New("no code in response")
@@ -224,7 +443,7 @@ func (l *LocalhostServer) accept(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	url := fmt.Sprintf("https://api.github.com/app-manifests/%s/conversions", code)
+	url := fmt.Sprintf("%s/app-manifests/%s/conversions", l.baseURL(), code)
 
 	req, err := http.NewRequest("POST", url, nil)
 	if err != nil {
@@ -261,11 +480,139 @@ func (l *LocalhostServer) accept(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if l.TFVarsPath != "" {
+		if err := info.WriteTFVars(l.TFVarsPath); err != nil {
+			fmt.Fprintf(w, "Failed! For more details, see your terminal. You can close this browser window.")
+			l.errors <- err
+			return
+		}
+	}
+
+	installed, err := l.saveInstallation(r.Context(), info)
+	if err != nil {
+		fmt.Fprintf(w, "Failed! For more details, see your terminal. You can close this browser window.")
+		l.errors <- err
+		return
+	}
+
+	if !installed {
+		fmt.Fprintf(w, "Almost done! Install the app at %s/apps/%s/installations/new. This tool will finish once the app is installed, and you can close this browser window.", l.webURL(), info.Slug)
+		l.mu.Lock()
+		l.awaiting = true
+		l.mu.Unlock()
+		go l.awaitInstallation(l.running, info)
+		return
+	}
+
 	fmt.Fprintf(w, "Success! You can close this browser window.")
 
 	l.done <- true
 }
 
+func (l *LocalhostServer) awaitingInstallation() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.awaiting
+}
+
+// awaitInstallation checks for the app's installation periodically, until it
+// is found or the context is cancelled.
+func (l *LocalhostServer) awaitInstallation(ctx context.Context, info response) {
+	poll := l.poll
+	if poll == 0 {
+		poll = 5 * time.Second
+	}
+
+	for {
+		select {
+		case <-time.After(poll):
+		case <-ctx.Done():
+			return
+		}
+
+		installed, err := l.saveInstallation(ctx, info)
+		if err != nil {
+			select {
+			case l.errors <- err:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		if installed {
+			select {
+			case l.done <- true:
+			case <-ctx.Done():
+			}
+			return
+		}
+	}
+}
+
+// saveInstallation stores the id of the app's first installation, and reports
+// whether the app has been installed.
+func (l *LocalhostServer) saveInstallation(ctx context.Context, info response) (bool, error) {
+	installationID, err := l.installation(ctx, info)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to lookup app installation")
+	}
+
+	if installationID == "" {
+		return false, nil
+	}
+
+	if err := save(ctx, l.Secrets, secrets.InstallationID, "The app's installation id", installationID); err != nil {
+		return false, errors.Wrap(err, "failed writing installation id to secrets manager")
+	}
+
+	return true, nil
+}
+
+// installation uses the new app's credentials to find the id of the app's first
+// installation. If the app has not been installed, the id is empty.
+func (l *LocalhostServer) installation(ctx context.Context, info response) (string, error) {
+	app := &invocation.AppInfo{ID: fmt.Sprint(info.ID), PEM: info.PEM}
+	jwt, err := app.JWT()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create jwt")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/app/installations", l.baseURL()), nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create GET request")
+	}
+	req.Header.Add("Accept", "application/vnd.github.v3+json")
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", jwt))
+
+	res, err := l.requester.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to send GET request")
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read response")
+	}
+
+	if res.StatusCode != 200 {
+		return "", errors.Errorf("unexpected api response %d: %s", res.StatusCode, body)
+	}
+
+	var installations []struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(body, &installations); err != nil {
+		return "", errors.Wrap(err, "failed to parse response")
+	}
+
+	if len(installations) == 0 {
+		return "", nil
+	}
+
+	return fmt.Sprint(installations[0].ID), nil
+}
+
 func (l *LocalhostServer) listen() {
 	if err := l.ListenAndServe(); err != http.ErrServerClosed {
 		l.errors <- err
@@ -2,17 +2,26 @@ package create
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
 	"github.com/golang/mock/gomock"
 	"github.com/rclark/aws-basics/github-app/create/mock"
 	"github.com/rclark/aws-basics/github-app/secrets"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -25,12 +34,17 @@ func TestCreateAppSuccess(t *testing.T) {
 	writer := mock.NewMockResponseWriter(ctrl)
 	requester := mock.NewMockRequester(ctrl)
 
+	pem, err := os.ReadFile("../tokens/invocation/test-key.pem")
+	require.NoError(t, err, "failed to read test pem file")
+	pemJSON, _ := json.Marshal(string(pem))
+
 	server := &LocalhostServer{
 		Server:    http.Server{Addr: ":6060"},
 		Secrets:   sm,
 		requester: requester,
 		done:      make(chan bool),
 		errors:    make(chan error),
+		state:     "the-state",
 	}
 
 	// In the test, instead of the browser being opened, we simulate the redirect
@@ -39,7 +53,7 @@ func TestCreateAppSuccess(t *testing.T) {
 	server.open = func(s string) error {
 		require.Equal(t, "http://localhost:6060", s, "opens user's browser to localhost url")
 
-		u, _ := url.Parse("http://localhost:6060/redirect?code=the-code")
+		u, _ := url.Parse("http://localhost:6060/redirect?code=the-code&state=the-state")
 
 		go func() {
 			server.accept(writer, &http.Request{
@@ -55,18 +69,33 @@ func TestCreateAppSuccess(t *testing.T) {
 	req, _ := http.NewRequest("POST", "https://api.github.com/app-manifests/the-code/conversions", nil)
 	req.Header.Add("Accept", "application/vnd.github.v3+json")
 	res := &http.Response{
-		Body: io.NopCloser(strings.NewReader(`{
+		Body: io.NopCloser(strings.NewReader(fmt.Sprintf(`{
 			"id": 101,
 			"slug": "aws-basics",
 			"name": "aws-basics",
 			"client_id": "client-id",
 			"client_secret": "client-secret",
 			"webhook_secret": "webhook-secret",
-			"pem": "pem"
-		}`)),
+			"pem": %s
+		}`, pemJSON))),
 	}
 	requester.EXPECT().Do(req).Return(res, nil)
 
+	// We expect the app's installations to be listed, using a JWT signed with the
+	// new app's pem.
+	requester.EXPECT().
+		Do(gomock.Any()).
+		DoAndReturn(func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, "GET", req.Method, "get request")
+			assert.Equal(t, "https://api.github.com/app/installations", req.URL.String(), "lists app installations")
+			assert.True(t, strings.HasPrefix(req.Header.Get("Authorization"), "Bearer "), "bearer authorization")
+
+			return &http.Response{
+				StatusCode: 200,
+				Body:       io.NopCloser(strings.NewReader(`[{"id": 12345}, {"id": 67890}]`)),
+			}, nil
+		})
+
 	// We expect secrets to be saved
 	sm.EXPECT().CreateSecret(ctx, &secretsmanager.CreateSecretInput{
 		Name:         aws.String(secrets.AppID),
@@ -95,7 +124,7 @@ func TestCreateAppSuccess(t *testing.T) {
 	sm.EXPECT().CreateSecret(ctx, &secretsmanager.CreateSecretInput{
 		Name:         aws.String(secrets.PEM),
 		Description:  aws.String("The app's pem"),
-		SecretString: aws.String("pem"),
+		SecretString: aws.String(string(pem)),
 	})
 
 	sm.EXPECT().CreateSecret(ctx, &secretsmanager.CreateSecretInput{
@@ -104,9 +133,409 @@ func TestCreateAppSuccess(t *testing.T) {
 		SecretString: aws.String("null"),
 	})
 
+	// We expect the first installation to be saved.
+	sm.EXPECT().CreateSecret(ctx, &secretsmanager.CreateSecretInput{
+		Name:         aws.String(secrets.InstallationID),
+		Description:  aws.String("The app's installation id"),
+		SecretString: aws.String("12345"),
+	})
+
 	// We expect a success message to be shown in the browser.
 	writer.EXPECT().Write([]byte("Success! You can close this browser window."))
 
+	err = server.CreateApp(ctx)
+	require.NoError(t, err, "should not error")
+}
+
+func TestPromptEnterprise(t *testing.T) {
+	server := &LocalhostServer{WebURL: "https://github.example.com"}
+
+	w := httptest.NewRecorder()
+	server.prompt(w, httptest.NewRequest("GET", "/", nil))
+
+	require.Contains(t, w.Body.String(), `action="https://github.example.com/settings/apps/new?state=`, "form submits to the enterprise server")
+}
+
+func TestPromptManifest(t *testing.T) {
+	server := &LocalhostServer{
+		Manifest: &Manifest{
+			Name:   "my-app",
+			URL:    "https://github.com/me/my-app",
+			Public: true,
+			Permissions: map[string]string{
+				"contents":      "write",
+				"pull_requests": "read",
+			},
+			Events: []string{"push", "pull_request"},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	server.prompt(w, httptest.NewRequest("GET", "/", nil))
+
+	match := regexp.MustCompile(`const manifest = (.*)`).FindStringSubmatch(w.Body.String())
+	require.Len(t, match, 2, "renders the manifest into the page")
+	require.JSONEq(t, `{
+		"name": "my-app",
+		"url": "https://github.com/me/my-app",
+		"redirect_url": "http://localhost:6060/redirect/",
+		"public": true,
+		"default_permissions": {
+			"contents": "write",
+			"pull_requests": "read"
+		},
+		"default_events": ["push", "pull_request"]
+	}`, match[1], "renders the customized manifest")
+}
+
+func TestCreateAppStateMismatch(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mock.NewMockSecretCreator(ctrl)
+	writer := mock.NewMockResponseWriter(ctrl)
+	requester := mock.NewMockRequester(ctrl)
+
+	server := &LocalhostServer{
+		Server:    http.Server{Addr: ":6060"},
+		Secrets:   sm,
+		requester: requester,
+		done:      make(chan bool),
+		errors:    make(chan error),
+		state:     "the-state",
+	}
+
+	// We simulate a redirect that did not result from the server's page, so its
+	// state does not match.
+	server.open = func(s string) error {
+		u, _ := url.Parse("http://localhost:6060/redirect?code=the-code&state=forged-state")
+
+		go func() {
+			server.accept(writer, &http.Request{
+				Method: "GET",
+				URL:    u,
+			})
+		}()
+
+		return nil
+	}
+
+	// We expect no request to GitHub, and a failure message to be shown in the
+	// browser.
+	writer.EXPECT().Write([]byte("Failed! For more details, see your terminal. You can close this browser window."))
+
 	err := server.CreateApp(ctx)
+	require.Error(t, err, "should error")
+	require.Contains(t, err.Error(), "state", "describes the state mismatch")
+}
+
+func TestPromptState(t *testing.T) {
+	server := &LocalhostServer{}
+
+	w := httptest.NewRecorder()
+	server.prompt(w, httptest.NewRequest("GET", "/", nil))
+
+	require.NotEmpty(t, server.state, "generates a state")
+	require.Contains(t, w.Body.String(), "/settings/apps/new?state="+server.state+`"`, "sends the state to GitHub")
+}
+
+func TestPromptStateRepeatedRequests(t *testing.T) {
+	server := &LocalhostServer{}
+
+	w := httptest.NewRecorder()
+	server.prompt(w, httptest.NewRequest("GET", "/", nil))
+	sent := server.state
+
+	// The browser requests a favicon, and the user reloads the page.
+	favicon := httptest.NewRecorder()
+	server.prompt(favicon, httptest.NewRequest("GET", "/favicon.ico", nil))
+	require.Equal(t, http.StatusNotFound, favicon.Code, "only serves the page at /")
+
+	reload := httptest.NewRecorder()
+	server.prompt(reload, httptest.NewRequest("GET", "/", nil))
+	require.Contains(t, reload.Body.String(), "/settings/apps/new?state="+sent+`"`, "sends the same state again")
+
+	require.True(t, server.validState(sent), "accepts the state from the first page")
+}
+
+func TestWriteTFVars(t *testing.T) {
+	info := response{
+		ID:            101,
+		Slug:          "aws-basics",
+		Name:          "aws-basics",
+		ClientID:      "client-id",
+		ClientSecret:  "client-secret",
+		WebhookSecret: "webhook-secret",
+		PEM:           "pem",
+	}
+
+	path := filepath.Join(t.TempDir(), "config.tfvars")
+	err := info.WriteTFVars(path)
+	require.NoError(t, err, "should not error")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err, "failed to read tfvars file")
+
+	require.Equal(t, `github-app-id        = "101"
+github-app-client-id = "client-id"
+github-app-slug      = "aws-basics"
+`, string(data), "writes non-secret values as HCL assignments")
+
+	for _, secret := range []string{info.ClientSecret, info.WebhookSecret, info.PEM} {
+		require.NotContains(t, string(data), secret, "does not write secret values")
+	}
+}
+
+func TestWriteTFVarsExistingFile(t *testing.T) {
+	info := response{
+		ID:            202,
+		Slug:          "aws-basics",
+		ClientID:      "new-client-id",
+		ClientSecret:  "client-secret",
+		WebhookSecret: "webhook-secret",
+		PEM:           "pem",
+	}
+
+	// The file already holds other configuration, and values from an app that
+	// was created previously.
+	path := filepath.Join(t.TempDir(), "config.tfvars")
+	err := os.WriteFile(path, []byte(`regions        = ["us-east-1"]
+primary-region = "us-east-1"
+webhook-secret = "keep-me"
+github-app-id = "101"
+github-app-client-id = "old-client-id"
+`), 0644)
+	require.NoError(t, err, "failed to write existing tfvars file")
+
+	err = info.WriteTFVars(path)
+	require.NoError(t, err, "should not error")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err, "failed to read tfvars file")
+
+	require.Equal(t, `regions        = ["us-east-1"]
+primary-region = "us-east-1"
+webhook-secret = "keep-me"
+github-app-id        = "202"
+github-app-client-id = "new-client-id"
+github-app-slug      = "aws-basics"
+`, string(data), "replaces the app's values and preserves everything else")
+}
+
+func TestSaveExistingSecrets(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mock.NewMockSecretCreator(ctrl)
+
+	info := response{
+		ID:            101,
+		Slug:          "aws-basics",
+		Name:          "aws-basics",
+		ClientID:      "client-id",
+		ClientSecret:  "client-secret",
+		WebhookSecret: "webhook-secret",
+		PEM:           "pem",
+	}
+
+	// We expect every secret to already exist, left behind by a previously
+	// created app, so each value should be put into the existing secret.
+	for _, secret := range []struct {
+		name        string
+		description string
+		value       string
+	}{
+		{secrets.AppID, "The app's id", "101"},
+		{secrets.ClientID, "The app's client id", "client-id"},
+		{secrets.ClientSecret, "The app's client secret", "client-secret"},
+		{secrets.WebhookSecret, "The app's webhook secret", "webhook-secret"},
+		{secrets.PEM, "The app's pem", "pem"},
+		{secrets.Token, "The app's token", "null"},
+	} {
+		gomock.InOrder(
+			sm.EXPECT().
+				CreateSecret(ctx, &secretsmanager.CreateSecretInput{
+					Name:         aws.String(secret.name),
+					Description:  aws.String(secret.description),
+					SecretString: aws.String(secret.value),
+				}).
+				Return(nil, &types.ResourceExistsException{}),
+			sm.EXPECT().PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+				SecretId:     aws.String(secret.name),
+				SecretString: aws.String(secret.value),
+			}),
+		)
+	}
+
+	err := info.Save(ctx, sm)
 	require.NoError(t, err, "should not error")
 }
+
+func TestCreateAppInterrupted(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	server := &LocalhostServer{
+		Server:    http.Server{Addr: ":6060"},
+		Secrets:   mock.NewMockSecretCreator(ctrl),
+		requester: mock.NewMockRequester(ctrl),
+		done:      make(chan bool),
+		errors:    make(chan error),
+		interrupt: make(chan os.Signal, 1),
+	}
+
+	// In the test, instead of the browser being opened, we simulate the user
+	// pressing Ctrl-C.
+	server.open = func(s string) error {
+		server.interrupt <- os.Interrupt
+		return nil
+	}
+
+	err := server.CreateApp(ctx)
+	require.EqualError(t, err, "cancelled by user", "returns the cancellation")
+	require.Equal(t, http.ErrServerClosed, server.ListenAndServe(), "shuts down the server")
+}
+
+func TestCreateAppAwaitsInstallation(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mock.NewMockSecretCreator(ctrl)
+	writer := mock.NewMockResponseWriter(ctrl)
+	requester := mock.NewMockRequester(ctrl)
+
+	pem, err := os.ReadFile("../tokens/invocation/test-key.pem")
+	require.NoError(t, err, "failed to read test pem file")
+	pemJSON, _ := json.Marshal(string(pem))
+
+	server := &LocalhostServer{
+		Server:    http.Server{Addr: ":6060"},
+		Secrets:   sm,
+		requester: requester,
+		done:      make(chan bool),
+		errors:    make(chan error),
+		state:     "the-state",
+		poll:      time.Millisecond,
+	}
+
+	server.open = func(s string) error {
+		u, _ := url.Parse("http://localhost:6060/redirect?code=the-code&state=the-state")
+
+		go func() {
+			server.accept(writer, &http.Request{
+				Method: "GET",
+				URL:    u,
+			})
+		}()
+
+		return nil
+	}
+
+	// We expect the app's credentials to be retrieved and saved, and the app's
+	// installations to be listed until one appears.
+	gomock.InOrder(
+		requester.EXPECT().
+			Do(gomock.Any()).
+			Return(&http.Response{
+				StatusCode: 201,
+				Body:       io.NopCloser(strings.NewReader(fmt.Sprintf(`{"id": 101, "slug": "aws-basics", "pem": %s}`, pemJSON))),
+			}, nil),
+		requester.EXPECT().
+			Do(gomock.Any()).
+			DoAndReturn(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: 200,
+					Body:       io.NopCloser(strings.NewReader(`[]`)),
+				}, nil
+			}).
+			Times(2),
+		requester.EXPECT().
+			Do(gomock.Any()).
+			Return(&http.Response{
+				StatusCode: 200,
+				Body:       io.NopCloser(strings.NewReader(`[{"id": 12345}]`)),
+			}, nil),
+	)
+
+	sm.EXPECT().CreateSecret(ctx, gomock.Any()).Times(6)
+
+	// We expect the installation to be saved once it is found.
+	sm.EXPECT().CreateSecret(gomock.Any(), &secretsmanager.CreateSecretInput{
+		Name:         aws.String(secrets.InstallationID),
+		Description:  aws.String("The app's installation id"),
+		SecretString: aws.String("12345"),
+	})
+
+	// We expect the user to be asked to install the app.
+	writer.EXPECT().Write([]byte("Almost done! Install the app at https://github.com/apps/aws-basics/installations/new. This tool will finish once the app is installed, and you can close this browser window."))
+
+	err = server.CreateApp(ctx)
+	require.NoError(t, err, "should not error")
+}
+
+func TestCreateAppInstallationTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mock.NewMockSecretCreator(ctrl)
+	writer := mock.NewMockResponseWriter(ctrl)
+	requester := mock.NewMockRequester(ctrl)
+
+	pem, err := os.ReadFile("../tokens/invocation/test-key.pem")
+	require.NoError(t, err, "failed to read test pem file")
+	pemJSON, _ := json.Marshal(string(pem))
+
+	server := &LocalhostServer{
+		Server:    http.Server{Addr: ":6060"},
+		Secrets:   sm,
+		requester: requester,
+		done:      make(chan bool),
+		errors:    make(chan error),
+		state:     "the-state",
+		poll:      time.Millisecond,
+	}
+
+	server.open = func(s string) error {
+		u, _ := url.Parse("http://localhost:6060/redirect?code=the-code&state=the-state")
+
+		go func() {
+			server.accept(writer, &http.Request{
+				Method: "GET",
+				URL:    u,
+			})
+		}()
+
+		return nil
+	}
+
+	// We expect the app to never be installed.
+	requester.EXPECT().
+		Do(gomock.Any()).
+		Return(&http.Response{
+			StatusCode: 201,
+			Body:       io.NopCloser(strings.NewReader(fmt.Sprintf(`{"id": 101, "slug": "aws-basics", "pem": %s}`, pemJSON))),
+		}, nil)
+	requester.EXPECT().
+		Do(gomock.Any()).
+		DoAndReturn(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: 200,
+				Body:       io.NopCloser(strings.NewReader(`[]`)),
+			}, nil
+		}).
+		AnyTimes()
+
+	sm.EXPECT().CreateSecret(gomock.Any(), gomock.Any()).Times(6)
+
+	writer.EXPECT().Write(gomock.Any())
+
+	err = server.CreateApp(ctx)
+	require.EqualError(t, err, "timed out waiting for the app to be installed. Its credentials are saved, so once it is installed, run this tool again, or store its installation id in the aws-basics/github-app/installation-id secret", "explains how to finish")
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
+	"os"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -12,6 +14,9 @@ import (
 )
 
 func main() {
+	tfvars := flag.String("tfvars", "", "optional path to write the new app's non-secret values as Terraform variables")
+	flag.Parse()
+
 	ctx := context.Background()
 
 	cfg, err := config.LoadDefaultConfig(ctx)
@@ -23,7 +28,12 @@ func main() {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
-	if err := create.NewLocalhostServer(sm).CreateApp(ctx); err != nil {
+	server := create.NewLocalhostServer(sm)
+	server.BaseURL = os.Getenv("GITHUB_API_URL")
+	server.WebURL = os.Getenv("GITHUB_SERVER_URL")
+	server.TFVarsPath = *tfvars
+
+	if err := server.CreateApp(ctx); err != nil {
 		log.Fatal(err)
 	}
 }
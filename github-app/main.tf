@@ -13,6 +13,15 @@ resource "aws_lambda_function" "handler" {
   s3_key    = "aws-basics/github-app/${var.bundle-version}.zip"
 
   source_code_hash = filebase64sha256("dist/github-app.zip")
+
+  environment {
+    variables = {
+      "GITHUB_INSTALLATION_IDS"   = join(",", var.installation-ids)
+      "GITHUB_TOKEN_REPOSITORIES" = join(",", var.repositories)
+      "GITHUB_API_URL"            = var.github-api-url
+      "TOKEN_REFRESH_TOPIC_ARN"   = var.token-refresh-topic-arn
+    }
+  }
 }
 
 resource "aws_s3_bucket_object" "bundle" {
@@ -37,7 +46,7 @@ resource "aws_iam_role_policy" "permissions" {
 
   policy = jsonencode({
     Version = "2012-10-17"
-    Statement = [
+    Statement = concat([
       {
         Action   = "logs:*"
         Effect   = "Allow"
@@ -46,12 +55,19 @@ resource "aws_iam_role_policy" "permissions" {
       {
         Action = [
           "secretsmanager:GetSecretValue",
-          "secretsmanager:PutSecretValue"
+          "secretsmanager:PutSecretValue",
+          "secretsmanager:CreateSecret"
         ]
         Effect   = "Allow"
         Resource = "arn:aws:secretsmanager:${var.region}:${var.account-id}:secret:aws-basics/github-app/*"
       }
-    ]
+      ], var.token-refresh-topic-arn == "" ? [] : [
+      {
+        Action   = "sns:Publish"
+        Effect   = "Allow"
+        Resource = var.token-refresh-topic-arn
+      }
+    ])
   })
 }
 
@@ -1,18 +1,24 @@
 package invocation
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
 	"github.com/golang-jwt/jwt"
 	"github.com/pkg/errors"
 	"github.com/rclark/aws-basics/github-app/secrets"
+	"github.com/rclark/aws-basics/utils"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -30,29 +36,205 @@ type Logger interface {
 	Print()
 }
 
-// SecretsReadWrite are the AWS SecretsManager methods for reading and updating
-// secrets.
+// SecretsReadWrite are the AWS SecretsManager methods for reading, updating,
+// and creating secrets.
 type SecretsReadWrite interface {
 	GetSecretValue(context.Context, *secretsmanager.GetSecretValueInput, ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
 	PutSecretValue(context.Context, *secretsmanager.PutSecretValueInput, ...func(*secretsmanager.Options)) (*secretsmanager.PutSecretValueOutput, error)
+	CreateSecret(context.Context, *secretsmanager.CreateSecretInput, ...func(*secretsmanager.Options)) (*secretsmanager.CreateSecretOutput, error)
 }
 
+// Notifier publishes messages to the systems that consume the app's tokens.
+type Notifier interface {
+	Publish(ctx context.Context, topicArn string, message string) error
+}
+
+// DefaultRefreshThreshold is how much of a stored token's lifetime must remain
+// for a Handler to skip refreshing it, when the Handler does not set
+// RefreshThreshold.
+const DefaultRefreshThreshold = 50 * time.Minute
+
+// DefaultBaseURL is the root of GitHub's REST API, used when a Handler does not
+// set BaseURL.
+const DefaultBaseURL = "https://api.github.com"
+
+// MaxJWTLifetime is the longest lifetime that GitHub accepts for an app's JWT.
+const MaxJWTLifetime = 10 * time.Minute
+
+// DefaultJWTLifetime is how long an app's JWT is valid for, when its lifetime
+// is not configured.
+const DefaultJWTLifetime = MaxJWTLifetime
+
+// DefaultClockSkew is how far an app's JWT is backdated to tolerate clocks
+// that drift from GitHub's, when the skew is not configured.
+const DefaultClockSkew = 1 * time.Minute
+
 // Handler is used to manage configurations for each Lambda invocation.
 type Handler struct {
 	Secrets   SecretsReadWrite
 	Logger    Logger
 	Requester Requester
+
+	// RefreshThreshold is how much of the stored token's lifetime must remain
+	// for the token to be reused rather than refreshed. Defaults to
+	// DefaultRefreshThreshold.
+	RefreshThreshold time.Duration
+
+	// InstallationIDs lists the app installations to refresh tokens for. When
+	// empty, the app's installation id is read from AWS SecretsManager.
+	InstallationIDs []string
+
+	// Repositories optionally limits the tokens to the named repositories. When
+	// empty, the tokens can access every repository in the installation.
+	Repositories []string
+
+	// BaseURL is the root of the GitHub REST API that issues tokens. GitHub
+	// Enterprise Server installations use a URL like https://HOST/api/v3.
+	// Defaults to DefaultBaseURL.
+	BaseURL string
+
+	// JWTLifetime is how long the JWT that is exchanged for tokens is valid for.
+	// Zero uses DefaultJWTLifetime. It must be positive, and cannot exceed
+	// MaxJWTLifetime.
+	JWTLifetime time.Duration
+
+	// ClockSkew is how far the JWT's issued at time is backdated. Zero uses
+	// DefaultClockSkew, so the issued at time is always backdated. It cannot be
+	// negative.
+	ClockSkew time.Duration
+
+	// Retry controls how a token request is retried after a network error or a
+	// 5xx response.
+	Retry utils.Retry
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+
+	// Notifier optionally publishes a message to TopicARN each time a token is
+	// refreshed.
+	Notifier Notifier
+	TopicARN string
+}
+
+type notification struct {
+	Secret    string    `json:"secret"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// notify tells consumers that the token in a secret has been refreshed.
+func (h *Handler) notify(ctx context.Context, secret string, expiresAt time.Time) error {
+	if h.Notifier == nil {
+		return nil
+	}
+
+	message, err := json.Marshal(notification{Secret: secret, ExpiresAt: expiresAt})
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize notification")
+	}
+
+	return h.Notifier.Publish(ctx, h.TopicARN, string(message))
+}
+
+func (h *Handler) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+
+	return time.Now()
+}
+
+func (h *Handler) baseURL() string {
+	if h.BaseURL != "" {
+		return strings.TrimSuffix(h.BaseURL, "/")
+	}
+
+	return DefaultBaseURL
+}
+
+func (h *Handler) refreshThreshold() time.Duration {
+	if h.RefreshThreshold > 0 {
+		return h.RefreshThreshold
+	}
+
+	return DefaultRefreshThreshold
+}
+
+// tokenSecret names the secret that stores an installation's token.
+func (h *Handler) tokenSecret(installationID string) string {
+	if len(h.InstallationIDs) == 0 {
+		return secrets.Token
+	}
+
+	return secrets.Token + "/" + installationID
+}
+
+// current reports whether the token stored in AWS SecretsManager will remain
+// valid for longer than the refresh threshold. A missing secret, or a stored
+// value that cannot be parsed, is treated as needing a refresh.
+func (h *Handler) current(ctx context.Context, secret string) (bool, error) {
+	res, err := h.Secrets.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secret),
+	})
+
+	var missing *types.ResourceNotFoundException
+	if errors.As(err, &missing) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, errors.Wrap(err, "failed to retrieve token")
+	}
+
+	var stored response
+	if err := json.Unmarshal([]byte(aws.ToString(res.SecretString)), &stored); err != nil {
+		return false, nil
+	}
+
+	return stored.ExpiresAt.Sub(h.now()) > h.refreshThreshold(), nil
+}
+
+// store writes the token to AWS SecretsManager, creating the secret if it does
+// not exist yet.
+func (h *Handler) store(ctx context.Context, secret string, token string) error {
+	_, err := h.Secrets.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:     aws.String(secret),
+		SecretString: aws.String(token),
+	})
+
+	var missing *types.ResourceNotFoundException
+	if errors.As(err, &missing) {
+		_, err = h.Secrets.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
+			Name:         aws.String(secret),
+			Description:  aws.String("The app's token"),
+			SecretString: aws.String(token),
+		})
+	}
+
+	return err
 }
 
 // AppInfo represents the data about a GitHub app that's required in order to
 // generate an API token representing the app.
 type AppInfo struct {
-	ID             string
-	InstallationID string
-	PEM            string
+	ID              string
+	InstallationIDs []string
+	PEM             string
+
+	// JWTLifetime is how long the app's JWT is valid for. Zero uses
+	// DefaultJWTLifetime. It must be positive, and cannot exceed MaxJWTLifetime.
+	JWTLifetime time.Duration
+
+	// ClockSkew is how far the JWT's issued at time is backdated. Zero uses
+	// DefaultClockSkew, so the issued at time is always backdated. It cannot be
+	// negative.
+	ClockSkew time.Duration
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
 }
 
-// Fetch gets the AppInfo data from AWS SecretsManager.
+// Fetch gets the AppInfo data from AWS SecretsManager. If no InstallationIDs
+// are already set, the app's single installation id is fetched as well.
 func (a *AppInfo) Fetch(ctx context.Context, sm SecretsReadWrite) error {
 	g := new(errgroup.Group)
 	g.Go(func() error {
@@ -66,16 +248,18 @@ func (a *AppInfo) Fetch(ctx context.Context, sm SecretsReadWrite) error {
 		return nil
 	})
 
-	g.Go(func() error {
-		res, err := sm.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
-			SecretId: aws.String(secrets.InstallationID),
+	if len(a.InstallationIDs) == 0 {
+		g.Go(func() error {
+			res, err := sm.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+				SecretId: aws.String(secrets.InstallationID),
+			})
+			if err != nil {
+				return errors.Wrap(err, "failed to retrieve installation id")
+			}
+			a.InstallationIDs = []string{*res.SecretString}
+			return nil
 		})
-		if err != nil {
-			return errors.Wrap(err, "failed to retrieve app id")
-		}
-		a.InstallationID = *res.SecretString
-		return nil
-	})
+	}
 
 	g.Go(func() error {
 		res, err := sm.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
@@ -94,11 +278,36 @@ func (a *AppInfo) Fetch(ctx context.Context, sm SecretsReadWrite) error {
 // JWT uses AppInfo data to generate a JWT. This token can be exchanged with
 // GitHub in order to recieve an API token.
 func (a *AppInfo) JWT() (string, error) {
+	lifetime := a.JWTLifetime
+	if lifetime == 0 {
+		lifetime = DefaultJWTLifetime
+	}
+
+	if lifetime <= 0 {
+		return "", errors.Errorf("jwt lifetime %s must be positive", lifetime)
+	}
+
+	if lifetime > MaxJWTLifetime {
+		return "", errors.Errorf("jwt lifetime %s exceeds GitHub's %s maximum", lifetime, MaxJWTLifetime)
+	}
+
+	skew := a.ClockSkew
+	if skew == 0 {
+		skew = DefaultClockSkew
+	}
+
+	if skew < 0 {
+		return "", errors.Errorf("jwt clock skew %s cannot be negative", skew)
+	}
+
 	now := time.Now().UTC()
+	if a.Now != nil {
+		now = a.Now().UTC()
+	}
 
 	claims := jwt.StandardClaims{
-		ExpiresAt: now.Add(10 * time.Minute).Unix(),
-		IssuedAt:  now.Add(-1 * time.Minute).Unix(),
+		ExpiresAt: now.Add(lifetime).Unix(),
+		IssuedAt:  now.Add(-skew).Unix(),
 		Issuer:    a.ID,
 	}
 
@@ -110,20 +319,75 @@ func (a *AppInfo) JWT() (string, error) {
 	return jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
 }
 
+// Validate fetches the app's PEM from AWS SecretsManager and checks that it can
+// be used to sign a JWT. It is intended to run once, when the Lambda function
+// starts, so that a misconfigured PEM fails fast.
+func (h *Handler) Validate(ctx context.Context) error {
+	res, err := h.Secrets.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secrets.PEM),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to retrieve app pem")
+	}
+
+	if _, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(aws.ToString(res.SecretString))); err != nil {
+		return errors.Wrapf(err, "app pem stored in %s is not a valid RSA private key", secrets.PEM)
+	}
+
+	return nil
+}
+
 type response struct {
-	Token string `json:"token"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 // Run is what each Lambda invocation does. The function fetches credentials for
 // the GitHub app from AWS SecretsManager. It uses those credentials to generate
 // a JWT according to GitHub's specifications (). It then provides that JWT to
 // GitHub in a request for an API access token. Finally, it updates the app's
-// token in AWS SecretsManager where other systems can access it.
+// token in AWS SecretsManager where other systems can access it. The token is
+// stored as JSON, providing the token itself and the time that it expires, for
+// example:
+//
+// {"token":"ghs_xxx","expires_at":"2021-10-20T00:00:00Z"}
+//
+// The function refreshes a token for each of the handler's InstallationIDs,
+// storing each one in a secret named for the installation, like
+// aws-basics/github-app/token/12345. If the handler does not list any
+// InstallationIDs, the app's single installation is read from AWS
+// SecretsManager, and its token is stored in aws-basics/github-app/token.
+//
+// Tokens are requested from GitHub's API at the handler's BaseURL, which allows
+// the function to work with GitHub Enterprise Server.
+//
+// If the handler lists Repositories, the tokens it requests can only access
+// those repositories.
+//
+// If the handler has a Notifier, each refreshed token is announced by
+// publishing a message to the handler's TopicARN, naming the secret and the
+// time that the new token expires, for example:
+//
+// {"secret":"aws-basics/github-app/token","expires_at":"2021-10-20T00:00:00Z"}
+//
+// A notification that cannot be published does not fail the function. It is
+// logged as NotifyError instead.
+//
+// Before fetching the app's credentials, the function reads the tokens that
+// are already stored. A token that will remain valid for longer than the
+// handler's RefreshThreshold is not refreshed. If no token needs to be
+// refreshed, the app's credentials are not fetched, and the function logs
+// Skipped: true.
 //
 // This Lambda function is intended to run every 10 minutes. The tokens
-// it generates expire after 60 minutes. As a result, any application that
-// accesses the app's token in AWS SecretsManager can expect to receive a token
-// that will be valid for at least 50 minutes.
+// it generates expire after 60 minutes. As a result, with the default refresh
+// threshold any application that accesses the app's token in AWS
+// SecretsManager can expect to receive a token that will be valid for at least
+// 40 minutes.
+//
+// If GitHub's API cannot be reached, or responds with a server error, the
+// request for a token is retried up to the handler's Retry.MaxRetries, and the
+// number of retries is logged as Retries.
 //
 // If the Lambda function fails for any reason, it will be retried up to 2 more
 // times by AWS. Logs for the Lambda function will only include information
@@ -139,7 +403,22 @@ func (h *Handler) Run(ctx context.Context) (err error) {
 		h.Logger.Print()
 	}()
 
-	info := new(AppInfo)
+	ids, stale, err := h.stale(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !stale {
+		h.Logger.Set("Skipped", "true")
+		return nil
+	}
+
+	info := &AppInfo{
+		InstallationIDs: ids,
+		JWTLifetime:     h.JWTLifetime,
+		ClockSkew:       h.ClockSkew,
+		Now:             h.now,
+	}
 	if err := info.Fetch(ctx, h.Secrets); err != nil {
 		return errors.Wrap(err, "failed to lookup app information in secrets manager")
 	}
@@ -149,23 +428,63 @@ func (h *Handler) Run(ctx context.Context) (err error) {
 		return errors.Wrap(err, "failed to create jwt")
 	}
 
-	url := fmt.Sprintf("https://api.github.com/app/installations/%s/access_tokens", info.InstallationID)
-	req, err := http.NewRequest("POST", url, nil)
-	if err != nil {
-		return errors.Wrap(err, "failed to create request")
+	for _, id := range info.InstallationIDs {
+		if err := h.refresh(ctx, jwt, id); err != nil {
+			return errors.Wrapf(err, "failed to refresh token for installation %s", id)
+		}
 	}
 
-	req.Header.Add("Accept", "application/vnd.github.v3+json")
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", jwt))
+	return nil
+}
 
-	res, err := h.Requester.Do(req)
-	if err != nil {
-		return errors.Wrap(err, "failed POST request for app token")
+// stale reads the stored tokens before any of the app's credentials are
+// fetched, and reports whether any of them need to be refreshed. It lists the
+// handler's InstallationIDs whose tokens are not current. If the handler does
+// not list any InstallationIDs, the app's single token is checked, and the list
+// is empty.
+func (h *Handler) stale(ctx context.Context) ([]string, bool, error) {
+	if len(h.InstallationIDs) == 0 {
+		current, err := h.current(ctx, secrets.Token)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "failed to lookup current token in secrets manager")
+		}
+
+		return nil, !current, nil
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
+	var ids []string
+	for _, id := range h.InstallationIDs {
+		current, err := h.current(ctx, h.tokenSecret(id))
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "failed to lookup current token for installation %s in secrets manager", id)
+		}
+
+		if !current {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, len(ids) > 0, nil
+}
+
+// refresh exchanges the JWT for a new installation token, and stores it in AWS
+// SecretsManager.
+func (h *Handler) refresh(ctx context.Context, jwt string, installationID string) error {
+	secret := h.tokenSecret(installationID)
+
+	var payload []byte
+	if len(h.Repositories) > 0 {
+		var err error
+		payload, err = json.Marshal(map[string][]string{"repositories": h.Repositories})
+		if err != nil {
+			return errors.Wrap(err, "failed to serialize request body")
+		}
+	}
+
+	url := fmt.Sprintf("%s/app/installations/%s/access_tokens", h.baseURL(), installationID)
+	res, body, err := h.post(ctx, url, jwt, payload)
 	if err != nil {
-		return errors.Wrap(err, "failed to read response body")
+		return err
 	}
 
 	if res.StatusCode != 201 {
@@ -179,10 +498,64 @@ func (h *Handler) Run(ctx context.Context) (err error) {
 		return errors.Wrap(err, "failed to parse response body")
 	}
 
-	_, err = h.Secrets.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
-		SecretId:     aws.String(secrets.Token),
-		SecretString: &r.Token,
+	stored, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize token")
+	}
+
+	if err := h.store(ctx, secret, string(stored)); err != nil {
+		return errors.Wrap(err, "failed to update token in secrets manager")
+	}
+
+	// The token is already stored, so failing here would only lead to a retry
+	// that finds the token current, and skips the notification anyway.
+	if err := h.notify(ctx, secret, r.ExpiresAt); err != nil {
+		h.Logger.Set("NotifyError", fmt.Sprintf("%+v", errors.Wrap(err, "failed to notify consumers of the refreshed token")))
+	}
+
+	return nil
+}
+
+// post sends the request for an installation token. Network errors and 5xx
+// responses are retried, up to the handler's Retry.MaxRetries.
+func (h *Handler) post(ctx context.Context, url string, jwt string, payload []byte) (*http.Response, []byte, error) {
+	var res *http.Response
+	var data []byte
+
+	retries, err := h.Retry.Do(ctx, func() (bool, error) {
+		var body io.Reader
+		if payload != nil {
+			body = bytes.NewReader(payload)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to create request")
+		}
+
+		req.Header.Add("Accept", "application/vnd.github.v3+json")
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", jwt))
+		if payload != nil {
+			req.Header.Add("Content-Type", "application/json")
+		}
+
+		res, err = h.Requester.Do(req)
+		if err != nil {
+			return true, errors.Wrap(err, "failed POST request for app token")
+		}
+
+		data, err = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return true, errors.Wrap(err, "failed to read response body")
+		}
+
+		return res.StatusCode >= 500, nil
 	})
 
-	return errors.Wrap(err, "failed to update token in secrets manager")
+	if retries > 0 {
+		h.Logger.Set("Retries", strconv.Itoa(retries))
+	}
+
+	return res, data, err
 }
@@ -2,6 +2,8 @@ package invocation
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
@@ -11,10 +13,12 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
 	"github.com/golang-jwt/jwt"
 	"github.com/golang/mock/gomock"
 	"github.com/rclark/aws-basics/github-app/secrets"
 	"github.com/rclark/aws-basics/github-app/tokens/invocation/mock"
+	"github.com/rclark/aws-basics/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -34,10 +38,25 @@ func TestRun(t *testing.T) {
 	public, err := jwt.ParseRSAPublicKeyFromPEM(pub)
 	require.NoError(t, err, "failed to parse public key from test pem file")
 
+	now := time.Date(2021, 10, 19, 23, 0, 0, 0, time.UTC)
+	lifetime := 8 * time.Minute
+	skew := 2 * time.Minute
+
 	// We expect the logger to be cleared, and printed
 	logger.EXPECT().Clear()
 	logger.EXPECT().Print()
 
+	// We expect the current token to be looked up in AWS SecretsManager, and
+	// found to be close to expiry.
+	expiring := now.Add(30 * time.Minute).Format(time.RFC3339)
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.Token),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String(fmt.Sprintf(`{"token":"old-token","expires_at":"%s"}`, expiring)),
+		}, nil)
+
 	// We expect credentials to be looked up in AWS SecretsManager
 	sm.EXPECT().
 		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
@@ -74,9 +93,17 @@ func TestRun(t *testing.T) {
 			assert.Equal(t, "api.github.com", req.URL.Host, "request to GitHub api")
 			assert.Equal(t, "/app/installations/installation-id/access_tokens", req.URL.Path, "request for app installation token")
 			assert.Equal(t, "application/vnd.github.v3+json", req.Header.Get("Accept"), "accept header")
+			assert.Nil(t, req.Body, "requests a token for every repository")
+			assert.Equal(t, ctx, req.Context(), "request is cancelled with the invocation")
 
 			// We expect the JWT provided in the request to be created properly.
-			token, err := jwt.Parse(req.Header.Get("Authorization"), func(token *jwt.Token) (interface{}, error) {
+			auth := req.Header.Get("Authorization")
+			require.True(t, strings.HasPrefix(auth, "Bearer "), "bearer authorization")
+
+			// The pinned clock produces a JWT that has already expired, so its
+			// claims are checked below rather than by the parser.
+			parser := &jwt.Parser{SkipClaimsValidation: true}
+			token, err := parser.Parse(strings.TrimPrefix(auth, "Bearer "), func(token *jwt.Token) (interface{}, error) {
 				sign, ok := token.Method.(*jwt.SigningMethodRSA)
 				require.True(t, ok, "jwt should be RSA")
 				require.Equal(t, "RS256", sign.Name, "jwt should use sha256")
@@ -89,32 +116,803 @@ func TestRun(t *testing.T) {
 			require.True(t, ok, "contains claims")
 			assert.Equal(t, "app-id", claims["iss"])
 
-			now := time.Now().UTC()
-			issued := time.Unix(int64(claims["iat"].(float64)), 0)
-			expires := time.Unix(int64(claims["exp"].(float64)), 0)
-
-			issuedRange := issued.Before(now.Add(-1*time.Minute)) && issued.After(now.Add(-2*time.Minute))
-			assert.True(t, issuedRange, "issued claim in expected timeframe")
-			expiresRange := expires.Before(now.Add(10*time.Minute)) && expires.After(now.Add(9*time.Minute))
-			assert.True(t, expiresRange, "expires claim in expected timeframe")
+			assert.Equal(t, float64(now.Add(-skew).Unix()), claims["iat"], "issued claim backdated by the clock skew")
+			assert.Equal(t, float64(now.Add(lifetime).Unix()), claims["exp"], "expires claim after the jwt lifetime")
 
 			return &http.Response{
-				Body: io.NopCloser(strings.NewReader(`{"token":"api-token"}`)),
+				StatusCode: 201,
+				Body:       io.NopCloser(strings.NewReader(`{"token":"api-token","expires_at":"2021-10-20T00:00:00Z"}`)),
 			}, nil
 		})
 
 	// We expect the token to be stored in AWS SecretsManager.
 	sm.EXPECT().PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
 		SecretId:     aws.String(secrets.Token),
-		SecretString: aws.String("api-token"),
+		SecretString: aws.String(`{"token":"api-token","expires_at":"2021-10-20T00:00:00Z"}`),
 	})
 
+	handler := &Handler{
+		Secrets:     sm,
+		Logger:      logger,
+		Requester:   requester,
+		JWTLifetime: lifetime,
+		ClockSkew:   skew,
+		Now:         func() time.Time { return now },
+	}
+
+	err = handler.Run(ctx)
+	require.NoError(t, err, "should not error")
+}
+
+func TestJWTInvalidTimes(t *testing.T) {
+	pem, err := os.ReadFile("test-key.pem")
+	require.NoError(t, err, "failed to read test pem file")
+
+	for name, info := range map[string]*AppInfo{
+		"lifetime too long": {JWTLifetime: 11 * time.Minute},
+		"negative lifetime": {JWTLifetime: -1 * time.Minute},
+		"negative skew":     {ClockSkew: -1 * time.Minute},
+	} {
+		t.Run(name, func(t *testing.T) {
+			info.ID = "app-id"
+			info.PEM = string(pem)
+
+			_, err := info.JWT()
+			require.Error(t, err, "should not create a jwt that GitHub will reject")
+		})
+	}
+}
+
+func TestRunSkipsCurrentToken(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mock.NewMockSecretsReadWrite(ctrl)
+	requester := mock.NewMockRequester(ctrl)
+	logger := mock.NewMockLogger(ctrl)
+	notifier := mock.NewMockNotifier(ctrl)
+
+	// We expect the logger to be cleared, record the skip, and be printed.
+	logger.EXPECT().Clear()
+	logger.EXPECT().Set("Skipped", "true")
+	logger.EXPECT().Print()
+
+	// We expect the current token to be looked up, and found to be valid for
+	// longer than the refresh threshold. The app's credentials should not be
+	// looked up, no new token should be requested, and no notification should be
+	// published.
+	expires := time.Now().UTC().Add(55 * time.Minute).Format(time.RFC3339)
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.Token),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String(fmt.Sprintf(`{"token":"api-token","expires_at":"%s"}`, expires)),
+		}, nil)
+
 	handler := &Handler{
 		Secrets:   sm,
 		Logger:    logger,
 		Requester: requester,
+		Notifier:  notifier,
+		TopicARN:  "arn:aws:sns:us-east-1:123456789012:token-refreshed",
+	}
+
+	err := handler.Run(ctx)
+	require.NoError(t, err, "should not error")
+}
+
+func TestRunMultipleInstallations(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mock.NewMockSecretsReadWrite(ctrl)
+	requester := mock.NewMockRequester(ctrl)
+	logger := mock.NewMockLogger(ctrl)
+
+	pem, err := os.ReadFile("test-key.pem")
+	require.NoError(t, err, "failed to read test pem file")
+
+	// We expect the logger to be cleared, and printed
+	logger.EXPECT().Clear()
+	logger.EXPECT().Print()
+
+	// We expect credentials to be looked up in AWS SecretsManager, but not the
+	// installation id, which is configured.
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.AppID),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("app-id"),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.PEM),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String(string(pem)),
+		}, nil)
+
+	// We expect each installation's token to be looked up. The second one has
+	// never been stored.
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.Token + "/111"),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("null"),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.Token + "/222"),
+		}).
+		Return(nil, &types.ResourceNotFoundException{})
+
+	// We expect one POST request to GitHub per installation, using the same JWT.
+	var jwts []string
+	requester.EXPECT().
+		Do(gomock.Any()).
+		DoAndReturn(func(req *http.Request) (*http.Response, error) {
+			id := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/app/installations/"), "/access_tokens")
+			jwts = append(jwts, req.Header.Get("Authorization"))
+
+			return &http.Response{
+				StatusCode: 201,
+				Body:       io.NopCloser(strings.NewReader(fmt.Sprintf(`{"token":"token-%s","expires_at":"2021-10-20T00:00:00Z"}`, id))),
+			}, nil
+		}).
+		Times(2)
+
+	// We expect each token to be stored in its installation's secret, creating
+	// the secret that does not exist yet.
+	sm.EXPECT().PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:     aws.String(secrets.Token + "/111"),
+		SecretString: aws.String(`{"token":"token-111","expires_at":"2021-10-20T00:00:00Z"}`),
+	})
+
+	sm.EXPECT().
+		PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+			SecretId:     aws.String(secrets.Token + "/222"),
+			SecretString: aws.String(`{"token":"token-222","expires_at":"2021-10-20T00:00:00Z"}`),
+		}).
+		Return(nil, &types.ResourceNotFoundException{})
+
+	sm.EXPECT().CreateSecret(ctx, &secretsmanager.CreateSecretInput{
+		Name:         aws.String(secrets.Token + "/222"),
+		Description:  aws.String("The app's token"),
+		SecretString: aws.String(`{"token":"token-222","expires_at":"2021-10-20T00:00:00Z"}`),
+	})
+
+	handler := &Handler{
+		Secrets:         sm,
+		Logger:          logger,
+		Requester:       requester,
+		InstallationIDs: []string{"111", "222"},
+	}
+
+	err = handler.Run(ctx)
+	require.NoError(t, err, "should not error")
+	require.Len(t, jwts, 2, "requests a token for each installation")
+	assert.Equal(t, jwts[0], jwts[1], "uses one jwt for every installation")
+}
+
+func TestRunRefreshesStaleInstallations(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mock.NewMockSecretsReadWrite(ctrl)
+	requester := mock.NewMockRequester(ctrl)
+	logger := mock.NewMockLogger(ctrl)
+
+	pem, err := os.ReadFile("test-key.pem")
+	require.NoError(t, err, "failed to read test pem file")
+
+	// We expect the logger to be cleared, and printed
+	logger.EXPECT().Clear()
+	logger.EXPECT().Print()
+
+	// We expect each installation's token to be looked up. Only the second one
+	// needs to be refreshed.
+	expires := time.Now().UTC().Add(55 * time.Minute).Format(time.RFC3339)
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.Token + "/111"),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String(fmt.Sprintf(`{"token":"token-111","expires_at":"%s"}`, expires)),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.Token + "/222"),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("null"),
+		}, nil)
+
+	// We expect credentials to be looked up in AWS SecretsManager
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.AppID),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("app-id"),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.PEM),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String(string(pem)),
+		}, nil)
+
+	// We expect a token to be requested and stored for the second installation
+	// only.
+	requester.EXPECT().
+		Do(gomock.Any()).
+		DoAndReturn(func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, "/app/installations/222/access_tokens", req.URL.Path, "requests the stale installation's token")
+
+			return &http.Response{
+				StatusCode: 201,
+				Body:       io.NopCloser(strings.NewReader(`{"token":"token-222","expires_at":"2021-10-20T00:00:00Z"}`)),
+			}, nil
+		})
+
+	sm.EXPECT().PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:     aws.String(secrets.Token + "/222"),
+		SecretString: aws.String(`{"token":"token-222","expires_at":"2021-10-20T00:00:00Z"}`),
+	})
+
+	handler := &Handler{
+		Secrets:         sm,
+		Logger:          logger,
+		Requester:       requester,
+		InstallationIDs: []string{"111", "222"},
+	}
+
+	err = handler.Run(ctx)
+	require.NoError(t, err, "should not error")
+}
+
+func TestRunScopedToRepositories(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mock.NewMockSecretsReadWrite(ctrl)
+	requester := mock.NewMockRequester(ctrl)
+	logger := mock.NewMockLogger(ctrl)
+
+	pem, err := os.ReadFile("test-key.pem")
+	require.NoError(t, err, "failed to read test pem file")
+
+	// We expect the logger to be cleared, and printed
+	logger.EXPECT().Clear()
+	logger.EXPECT().Print()
+
+	// We expect credentials and the current token to be looked up in AWS
+	// SecretsManager
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.AppID),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("app-id"),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.InstallationID),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("installation-id"),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.PEM),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String(string(pem)),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.Token),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("null"),
+		}, nil)
+
+	// We expect the POST request to GitHub to list the repositories.
+	requester.EXPECT().
+		Do(gomock.Any()).
+		DoAndReturn(func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, "application/json", req.Header.Get("Content-Type"), "content-type header")
+
+			require.NotNil(t, req.Body, "sends a request body")
+			body, err := io.ReadAll(req.Body)
+			require.NoError(t, err, "failed to read request body")
+			assert.JSONEq(t, `{"repositories":["aws-basics","dotfiles"]}`, string(body), "requests a token for specific repositories")
+
+			return &http.Response{
+				StatusCode: 201,
+				Body:       io.NopCloser(strings.NewReader(`{"token":"api-token","expires_at":"2021-10-20T00:00:00Z"}`)),
+			}, nil
+		})
+
+	sm.EXPECT().PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:     aws.String(secrets.Token),
+		SecretString: aws.String(`{"token":"api-token","expires_at":"2021-10-20T00:00:00Z"}`),
+	})
+
+	handler := &Handler{
+		Secrets:      sm,
+		Logger:       logger,
+		Requester:    requester,
+		Repositories: []string{"aws-basics", "dotfiles"},
 	}
 
 	err = handler.Run(ctx)
 	require.NoError(t, err, "should not error")
 }
+
+func TestRunEnterprise(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mock.NewMockSecretsReadWrite(ctrl)
+	requester := mock.NewMockRequester(ctrl)
+	logger := mock.NewMockLogger(ctrl)
+
+	pem, err := os.ReadFile("test-key.pem")
+	require.NoError(t, err, "failed to read test pem file")
+
+	// We expect the logger to be cleared, and printed
+	logger.EXPECT().Clear()
+	logger.EXPECT().Print()
+
+	// We expect credentials and the current token to be looked up in AWS
+	// SecretsManager
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.AppID),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("app-id"),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.InstallationID),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("installation-id"),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.PEM),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String(string(pem)),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.Token),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("null"),
+		}, nil)
+
+	// We expect the POST request to be sent to the enterprise server's API.
+	requester.EXPECT().
+		Do(gomock.Any()).
+		DoAndReturn(func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, "https", req.URL.Scheme, "https request")
+			assert.Equal(t, "github.example.com", req.URL.Host, "request to enterprise host")
+			assert.Equal(t, "/api/v3/app/installations/installation-id/access_tokens", req.URL.Path, "request for app installation token")
+
+			return &http.Response{
+				StatusCode: 201,
+				Body:       io.NopCloser(strings.NewReader(`{"token":"api-token","expires_at":"2021-10-20T00:00:00Z"}`)),
+			}, nil
+		})
+
+	sm.EXPECT().PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:     aws.String(secrets.Token),
+		SecretString: aws.String(`{"token":"api-token","expires_at":"2021-10-20T00:00:00Z"}`),
+	})
+
+	handler := &Handler{
+		Secrets:   sm,
+		Logger:    logger,
+		Requester: requester,
+		BaseURL:   "https://github.example.com/api/v3/",
+	}
+
+	err = handler.Run(ctx)
+	require.NoError(t, err, "should not error")
+}
+
+func TestRunRetriesServerErrors(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mock.NewMockSecretsReadWrite(ctrl)
+	requester := mock.NewMockRequester(ctrl)
+	logger := mock.NewMockLogger(ctrl)
+
+	pem, err := os.ReadFile("test-key.pem")
+	require.NoError(t, err, "failed to read test pem file")
+
+	// We expect the logger to be cleared, record the retry, and printed
+	logger.EXPECT().Clear()
+	logger.EXPECT().Set("Retries", "1")
+	logger.EXPECT().Print()
+
+	// We expect credentials and the current token to be looked up in AWS
+	// SecretsManager
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.AppID),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("app-id"),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.InstallationID),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("installation-id"),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.PEM),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String(string(pem)),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.Token),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("null"),
+		}, nil)
+
+	// We expect the first request to fail with a server error, and the second to
+	// succeed.
+	gomock.InOrder(
+		requester.EXPECT().
+			Do(gomock.Any()).
+			Return(&http.Response{
+				StatusCode: 502,
+				Body:       io.NopCloser(strings.NewReader("Bad Gateway")),
+			}, nil),
+		requester.EXPECT().
+			Do(gomock.Any()).
+			Return(&http.Response{
+				StatusCode: 201,
+				Body:       io.NopCloser(strings.NewReader(`{"token":"api-token","expires_at":"2021-10-20T00:00:00Z"}`)),
+			}, nil),
+	)
+
+	sm.EXPECT().PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:     aws.String(secrets.Token),
+		SecretString: aws.String(`{"token":"api-token","expires_at":"2021-10-20T00:00:00Z"}`),
+	})
+
+	handler := &Handler{
+		Secrets:   sm,
+		Logger:    logger,
+		Requester: requester,
+		Retry:     utils.Retry{Backoff: func(int) time.Duration { return 0 }},
+	}
+
+	err = handler.Run(ctx)
+	require.NoError(t, err, "should not error")
+}
+
+func TestRunDoesNotRetryClientErrors(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mock.NewMockSecretsReadWrite(ctrl)
+	requester := mock.NewMockRequester(ctrl)
+	logger := mock.NewMockLogger(ctrl)
+
+	pem, err := os.ReadFile("test-key.pem")
+	require.NoError(t, err, "failed to read test pem file")
+
+	// We expect the logger to be cleared, record the failed response, and
+	// printed
+	logger.EXPECT().Clear()
+	logger.EXPECT().Set("StatusCode", "404 Not Found")
+	logger.EXPECT().Set("Response", `{"message":"Not Found"}`)
+	logger.EXPECT().Set("Error", gomock.Any())
+	logger.EXPECT().Print()
+
+	// We expect credentials and the current token to be looked up in AWS
+	// SecretsManager
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.AppID),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("app-id"),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.InstallationID),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("installation-id"),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.PEM),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String(string(pem)),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.Token),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("null"),
+		}, nil)
+
+	// We expect a single request, which fails with a client error.
+	requester.EXPECT().
+		Do(gomock.Any()).
+		Return(&http.Response{
+			Status:     "404 Not Found",
+			StatusCode: 404,
+			Body:       io.NopCloser(strings.NewReader(`{"message":"Not Found"}`)),
+		}, nil)
+
+	handler := &Handler{
+		Secrets:   sm,
+		Logger:    logger,
+		Requester: requester,
+		Retry:     utils.Retry{Backoff: func(int) time.Duration { return 0 }},
+	}
+
+	err = handler.Run(ctx)
+	require.Error(t, err, "should error")
+}
+
+func TestRunNotifiesRefresh(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mock.NewMockSecretsReadWrite(ctrl)
+	requester := mock.NewMockRequester(ctrl)
+	logger := mock.NewMockLogger(ctrl)
+	notifier := mock.NewMockNotifier(ctrl)
+
+	pem, err := os.ReadFile("test-key.pem")
+	require.NoError(t, err, "failed to read test pem file")
+
+	// We expect the logger to be cleared, and printed
+	logger.EXPECT().Clear()
+	logger.EXPECT().Print()
+
+	// We expect credentials and the current token to be looked up in AWS
+	// SecretsManager
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.AppID),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("app-id"),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.InstallationID),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("installation-id"),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.PEM),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String(string(pem)),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.Token),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("null"),
+		}, nil)
+
+	requester.EXPECT().
+		Do(gomock.Any()).
+		Return(&http.Response{
+			StatusCode: 201,
+			Body:       io.NopCloser(strings.NewReader(`{"token":"api-token","expires_at":"2021-10-20T00:00:00Z"}`)),
+		}, nil)
+
+	// We expect the notification to be published after the token is stored.
+	gomock.InOrder(
+		sm.EXPECT().PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+			SecretId:     aws.String(secrets.Token),
+			SecretString: aws.String(`{"token":"api-token","expires_at":"2021-10-20T00:00:00Z"}`),
+		}),
+		notifier.EXPECT().Publish(
+			ctx,
+			"arn:aws:sns:us-east-1:123456789012:token-refreshed",
+			`{"secret":"aws-basics/github-app/token","expires_at":"2021-10-20T00:00:00Z"}`,
+		),
+	)
+
+	handler := &Handler{
+		Secrets:   sm,
+		Logger:    logger,
+		Requester: requester,
+		Notifier:  notifier,
+		TopicARN:  "arn:aws:sns:us-east-1:123456789012:token-refreshed",
+	}
+
+	err = handler.Run(ctx)
+	require.NoError(t, err, "should not error")
+}
+
+func TestValidate(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mock.NewMockSecretsReadWrite(ctrl)
+
+	pem, err := os.ReadFile("test-key.pem")
+	require.NoError(t, err, "failed to read test pem file")
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.PEM),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String(string(pem)),
+		}, nil)
+
+	handler := &Handler{Secrets: sm}
+
+	err = handler.Validate(ctx)
+	require.NoError(t, err, "accepts a valid pem")
+}
+
+func TestValidateBadPEM(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mock.NewMockSecretsReadWrite(ctrl)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.PEM),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("not a pem"),
+		}, nil)
+
+	handler := &Handler{Secrets: sm}
+
+	err := handler.Validate(ctx)
+	require.Error(t, err, "rejects an invalid pem")
+	assert.Contains(t, err.Error(), "not a valid RSA private key", "describes the problem")
+}
+
+func TestRunNotifyFailure(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mock.NewMockSecretsReadWrite(ctrl)
+	requester := mock.NewMockRequester(ctrl)
+	logger := mock.NewMockLogger(ctrl)
+	notifier := mock.NewMockNotifier(ctrl)
+
+	pem, err := os.ReadFile("test-key.pem")
+	require.NoError(t, err, "failed to read test pem file")
+
+	// We expect the logger to be cleared, record the failed notification, and
+	// printed
+	logger.EXPECT().Clear()
+	logger.EXPECT().Set("NotifyError", gomock.Any())
+	logger.EXPECT().Print()
+
+	// We expect credentials and the current token to be looked up in AWS
+	// SecretsManager
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.AppID),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("app-id"),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.InstallationID),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("installation-id"),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.PEM),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String(string(pem)),
+		}, nil)
+
+	sm.EXPECT().
+		GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secrets.Token),
+		}).
+		Return(&secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("null"),
+		}, nil)
+
+	requester.EXPECT().
+		Do(gomock.Any()).
+		Return(&http.Response{
+			StatusCode: 201,
+			Body:       io.NopCloser(strings.NewReader(`{"token":"api-token","expires_at":"2021-10-20T00:00:00Z"}`)),
+		}, nil)
+
+	// We expect the notification to fail after the token is stored.
+	gomock.InOrder(
+		sm.EXPECT().PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+			SecretId:     aws.String(secrets.Token),
+			SecretString: aws.String(`{"token":"api-token","expires_at":"2021-10-20T00:00:00Z"}`),
+		}),
+		notifier.EXPECT().Publish(
+			ctx,
+			"arn:aws:sns:us-east-1:123456789012:token-refreshed",
+			`{"secret":"aws-basics/github-app/token","expires_at":"2021-10-20T00:00:00Z"}`,
+		).Return(errors.New("sns unavailable")),
+	)
+
+	handler := &Handler{
+		Secrets:   sm,
+		Logger:    logger,
+		Requester: requester,
+		Notifier:  notifier,
+		TopicARN:  "arn:aws:sns:us-east-1:123456789012:token-refreshed",
+	}
+
+	err = handler.Run(ctx)
+	require.NoError(t, err, "a stored token is not failed by its notification")
+}
@@ -4,10 +4,13 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
 	"github.com/pkg/errors"
 	"github.com/rclark/aws-basics/github-app/tokens/invocation"
 	"github.com/rclark/aws-basics/utils"
@@ -25,5 +28,32 @@ func main() {
 		Requester: http.DefaultClient,
 	}
 
+	handler.InstallationIDs = utils.SplitList(os.Getenv("GITHUB_INSTALLATION_IDS"))
+	handler.Repositories = utils.SplitList(os.Getenv("GITHUB_TOKEN_REPOSITORIES"))
+
+	handler.BaseURL = os.Getenv("GITHUB_API_URL")
+
+	if topic := os.Getenv("TOKEN_REFRESH_TOPIC_ARN"); topic != "" {
+		handler.Notifier = snsNotifier{sns.NewFromConfig(cfg)}
+		handler.TopicARN = topic
+	}
+
+	if err := handler.Validate(context.Background()); err != nil {
+		log.Fatalf("%+v", err)
+	}
+
 	lambda.Start(handler.Run)
 }
+
+// snsNotifier publishes the handler's notifications to an SNS topic.
+type snsNotifier struct {
+	client *sns.Client
+}
+
+func (n snsNotifier) Publish(ctx context.Context, topicArn string, message string) error {
+	_, err := n.client.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(topicArn),
+		Message:  aws.String(message),
+	})
+	return errors.Wrap(err, "failed SNS Publish API call")
+}
@@ -22,3 +22,23 @@ variable "region" {
 variable "account-id" {
   type = string
 }
+
+variable "installation-ids" {
+  type    = list(string)
+  default = []
+}
+
+variable "repositories" {
+  type    = list(string)
+  default = []
+}
+
+variable "github-api-url" {
+  type    = string
+  default = "https://api.github.com"
+}
+
+variable "token-refresh-topic-arn" {
+  type    = string
+  default = ""
+}
@@ -3,16 +3,18 @@ package invocation
 import (
 	"context"
 	"crypto/hmac"
+	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/cloudwatchevents"
-	"github.com/aws/aws-sdk-go-v2/service/cloudwatchevents/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
 	"github.com/pkg/errors"
+	"github.com/rclark/aws-basics/utils"
 )
 
 //go:generate mockgen -source ./handler.go -package mock -destination ./mock/handler.go
@@ -24,11 +26,21 @@ type Logger interface {
 	Print()
 }
 
-// CanPutEvents represents the CloudWatch Events PutEvents API method.
+// Metrics is used to publish operational metrics about each invocation.
+type Metrics interface {
+	Count(name string, value float64)
+}
+
+// CanPutEvents represents the EventBridge PutEvents API method.
 type CanPutEvents interface {
-	PutEvents(ctx context.Context, params *cloudwatchevents.PutEventsInput, optFns ...func(*cloudwatchevents.Options)) (*cloudwatchevents.PutEventsOutput, error)
+	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
 }
 
+// DefaultMaxPayloadBytes is the largest payload that is forwarded when a Handler
+// does not set MaxPayloadBytes. It matches EventBridge's limit on the size of a
+// PutEvents request.
+const DefaultMaxPayloadBytes = 256000
+
 // Handler stores configuration that is reusable across Lambda function
 // invocations.
 type Handler struct {
@@ -36,22 +48,125 @@ type Handler struct {
 	Bus    string
 	Events CanPutEvents
 	Logger Logger
+
+	// Metrics optionally receives counters for the outcome of each invocation.
+	Metrics Metrics
+
+	// AllowedEvents optionally limits the GitHub event types that are forwarded
+	// to the bus. When empty, every event type is forwarded.
+	AllowedEvents []string
+
+	// BusRouting optionally maps GitHub event types, in any case, to the name
+	// of the bus that should receive them. Event types without an entry are
+	// sent to Bus.
+	BusRouting map[string]string
+
+	// MaxPayloadBytes is the largest payload that the handler will forward.
+	// Defaults to DefaultMaxPayloadBytes.
+	MaxPayloadBytes int
+
+	// WrapEnvelope sends consumers a JSON envelope containing the delivery GUID,
+	// the event type, and the original payload, rather than the payload alone.
+	WrapEnvelope bool
+
+	// Target optionally replaces the EventBridge bus as the destination for
+	// validated events.
+	Target Target
+
+	// Retry controls how delivery of an event is retried after a failure.
+	Retry utils.Retry
+}
+
+func (h *Handler) count(name string) {
+	if h.Metrics != nil {
+		h.Metrics.Count(name, 1)
+	}
+}
+
+func (h *Handler) maxPayloadBytes() int {
+	if h.MaxPayloadBytes > 0 {
+		return h.MaxPayloadBytes
+	}
+
+	return DefaultMaxPayloadBytes
+}
+
+func (h *Handler) send(ctx context.Context, eventType string, body []byte) error {
+	target := h.target()
+	retries, err := h.Retry.Do(ctx, func() (bool, error) {
+		err := target.Send(ctx, eventType, body)
+		return err != nil, err
+	})
+
+	if retries > 0 {
+		h.Logger.Set("Retries", strconv.Itoa(retries))
+	}
+
+	return err
+}
+
+type envelope struct {
+	Delivery string          `json:"delivery"`
+	Event    string          `json:"event"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+func (h *Handler) target() Target {
+	if h.Target != nil {
+		return h.Target
+	}
+
+	return &EventBusTarget{
+		Events:     h.Events,
+		Bus:        h.Bus,
+		BusRouting: h.BusRouting,
+		Logger:     h.Logger,
+	}
+}
+
+func (h *Handler) allowed(eventType string) bool {
+	if len(h.AllowedEvents) == 0 {
+		return true
+	}
+
+	for _, allowed := range h.AllowedEvents {
+		if strings.ToLower(allowed) == eventType {
+			return true
+		}
+	}
+
+	return false
 }
 
 // Run is the code to execute on each Lambda function invocation. The function
 // receives an event representing a request to API gateway. It validates that
 // the request came from GitHub, by verifying the signature provided in the
 // X-Hub-Signature-256 header was produced using the shared secret that is
-// configured for the system's GitHub App. See https://docs.github.com/en/developers/webhooks-and-events/webhooks/securing-your-webhooks#validating-payloads-from-github
+// configured for the system's GitHub App. If that header is absent, the legacy
+// SHA-1 signature in the X-Hub-Signature header is validated instead. See https://docs.github.com/en/developers/webhooks-and-events/webhooks/securing-your-webhooks#validating-payloads-from-github
 // for more information about signature verification.
 //
-// If the signature is valid, the function produces a single CloudWatch Event
-// representing the payload it received from GitHub. The function may result in
+// If the signature is valid, the function produces a single EventBridge event
+// representing the payload it received from GitHub, on the bus that the
+// handler's BusRouting assigns to the event type, or on Bus by default. If the
+// handler has a Target, the payload is delivered there instead. If the handler
+// sets WrapEnvelope, the payload is delivered inside a JSON object alongside the
+// request's delivery GUID and event type. The function may result in
 // the following HTTP response status codes:
 //
 // • 401: Invalid requests or signature mismatch.
 //
-// • 500: Failed to make the PutEvents API call.
+// • 413: The payload is larger than the handler's MaxPayloadBytes, including
+// its envelope if the handler sets WrapEnvelope. The logged Error begins with
+// PayloadTooLarge.
+//
+// • 200: The request was a ping event, sent by GitHub when a webhook is
+// configured.
+//
+// • 204: The event type is not in the handler's AllowedEvents.
+//
+// • 500: Failed to deliver the event to its target, after retrying up to the
+// handler's Retry.MaxRetries.
 //
 // • 201: Success.
 //
@@ -65,79 +180,159 @@ type Handler struct {
 // • SignatureExpected: The signature calculated by the Lambda invocation.
 //
 // • SignatureFound: The signature provided by the request's
-// X-Hub-Signature-256 header.
+// X-Hub-Signature-256 or X-Hub-Signature header.
+//
+// • SignatureAlgorithm: The hash algorithm used to validate the signature,
+// either sha256 or sha1.
 //
 // • EventType: The lower-cased name of the type of GitHub event this request
 // represents, as provided in the request's X-GitHub-Event header.
 //
-// • Error: If there was a 401 or 500 response, this will provide a description
-// of the failure that was encountered, and a stack trace in case debugging is
-// neccessary.
-func (h *Handler) Run(ctx context.Context, event events.APIGatewayV2HTTPRequest) (response events.APIGatewayV2HTTPResponse, err error) {
+// • Retries: The number of times that delivery of the event was retried, if
+// the first attempt failed.
+//
+// • Pong: Set to true if the request was a ping event, which is not forwarded.
+//
+// • TargetBus: The name of the bus that the event was sent to.
+//
+// • TargetQueue: The URL of the SQS queue that the event was sent to, if the
+// handler's Target is a QueueTarget.
+//
+// • Skipped: The event type, if the event was not forwarded because it is not
+// in the handler's AllowedEvents.
+//
+// • Error: If there was a 401, 413 or 500 response, this will provide a
+// description of the failure that was encountered, and a stack trace in case
+// debugging is neccessary.
+//
+// If the handler has Metrics, the invocation also increments one of the
+// following counters:
+//
+// • Accepted: The event was delivered to its target.
+//
+// • SignatureRejected: The signature was missing or did not match.
+//
+// • PutEventsFailed: The event could not be delivered to its target.
+func (h *Handler) Run(ctx context.Context, event events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	status := h.handle(ctx, event.Headers, event.Body, event.IsBase64Encoded)
+	return events.APIGatewayV2HTTPResponse{StatusCode: status}, nil
+}
+
+// RunV1 is the code to execute on each Lambda function invocation when the
+// function is invoked by an API Gateway REST API, rather than an HTTP API. It
+// otherwise behaves exactly like Run.
+func (h *Handler) RunV1(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	status := h.handle(ctx, event.Headers, event.Body, event.IsBase64Encoded)
+	return events.APIGatewayProxyResponse{StatusCode: status}, nil
+}
+
+func (h *Handler) handle(ctx context.Context, requestHeaders map[string]string, content string, isBase64Encoded bool) int {
 	h.Logger.Clear()
 
 	defer func() {
 		h.Logger.Print()
 	}()
 
-	response = events.APIGatewayV2HTTPResponse{StatusCode: 401}
+	headers := make(map[string]string, len(requestHeaders))
+	for key, val := range requestHeaders {
+		headers[strings.ToLower(key)] = val
+	}
 
-	delivery, ok := event.Headers["x-github-delivery"]
+	delivery, ok := headers["x-github-delivery"]
 	if !ok {
 		h.Logger.Set("Error", fmt.Sprintf("%+v", errors.New("missing delivery header")))
-		return response, nil
+		return 401
 	}
 	h.Logger.Set("Delivery", delivery)
 
-	body := []byte(event.Body)
-	if event.IsBase64Encoded {
-		b, err := base64.RawStdEncoding.DecodeString(event.Body)
+	body := []byte(content)
+	if isBase64Encoded {
+		// API Gateway pads the encoded body, but unpadded bodies are accepted too.
+		b, err := base64.StdEncoding.DecodeString(content)
+		if err != nil {
+			b, err = base64.RawStdEncoding.DecodeString(content)
+		}
 		if err != nil {
 			h.Logger.Set("Error", fmt.Sprintf("%+v", errors.Wrap(err, "failed to decode request body")))
-			return response, nil
+			return 401
 		}
 		body = b
 	}
 
-	hash := hmac.New(sha256.New, []byte(h.Secret))
+	if max := h.maxPayloadBytes(); len(body) > max {
+		h.Logger.Set("Error", fmt.Sprintf("%+v", errors.Errorf("PayloadTooLarge: %d bytes exceeds the %d byte limit", len(body), max)))
+		return 413
+	}
+
+	algorithm, header, digest := "sha256", "x-hub-signature-256", sha256.New
+	if _, ok := headers[header]; !ok {
+		if _, ok := headers["x-hub-signature"]; ok {
+			algorithm, header, digest = "sha1", "x-hub-signature", sha1.New
+		}
+	}
+
+	hash := hmac.New(digest, []byte(h.Secret))
 	hash.Write(body)
-	expected := fmt.Sprintf("sha256=%x", hash.Sum(nil))
+	expected := fmt.Sprintf("%s=%x", algorithm, hash.Sum(nil))
 	h.Logger.Set("SignatureExpected", expected)
 
-	signature, ok := event.Headers["x-hub-signature-256"]
+	signature, ok := headers[header]
 	if !ok {
 		h.Logger.Set("Error", fmt.Sprintf("%+v", errors.New("no signature header")))
-		return response, nil
+		h.count("SignatureRejected")
+		return 401
 	}
 	h.Logger.Set("SignatureFound", signature)
+	h.Logger.Set("SignatureAlgorithm", algorithm)
 
 	if signature != expected {
 		h.Logger.Set("Error", fmt.Sprintf("%+v", errors.New("signature mismatch")))
-		return response, nil
+		h.count("SignatureRejected")
+		return 401
 	}
 
-	eventType, ok := event.Headers["x-github-event"]
+	eventType, ok := headers["x-github-event"]
 	if !ok {
 		h.Logger.Set("Error", fmt.Sprintf("%+v", errors.New("missing event type header")))
-		return response, nil
+		return 401
 	}
 	eventType = strings.ToLower(eventType)
 	h.Logger.Set("EventType", eventType)
 
-	_, err = h.Events.PutEvents(ctx, &cloudwatchevents.PutEventsInput{
-		Entries: []types.PutEventsRequestEntry{{
-			Detail:       aws.String(string(body)),
-			DetailType:   aws.String(eventType),
-			EventBusName: aws.String(h.Bus),
-			Source:       aws.String("github"),
-		}},
-	})
-	if err != nil {
-		h.Logger.Set("Error", fmt.Sprintf("%+v", errors.Wrap(err, "failed PutEvents API call")))
-		response.StatusCode = 500
-		return response, nil
+	if eventType == "ping" {
+		h.Logger.Set("Pong", "true")
+		return 200
+	}
+
+	if !h.allowed(eventType) {
+		h.Logger.Set("Skipped", eventType)
+		return 204
+	}
+
+	if h.WrapEnvelope {
+		wrapped, err := json.Marshal(envelope{
+			Delivery: delivery,
+			Event:    eventType,
+			Payload:  body,
+		})
+		if err != nil {
+			h.Logger.Set("Error", fmt.Sprintf("%+v", errors.Wrap(err, "failed to wrap payload in envelope")))
+			return 500
+		}
+		body = wrapped
+
+		if max := h.maxPayloadBytes(); len(body) > max {
+			h.Logger.Set("Error", fmt.Sprintf("%+v", errors.Errorf("PayloadTooLarge: %d bytes in its envelope exceeds the %d byte limit", len(body), max)))
+			return 413
+		}
+	}
+
+	if err := h.send(ctx, eventType, body); err != nil {
+		h.Logger.Set("Error", fmt.Sprintf("%+v", err))
+		h.count("PutEventsFailed")
+		return 500
 	}
 
-	response.StatusCode = 201
-	return response, nil
+	h.count("Accepted")
+	return 201
 }
@@ -3,16 +3,21 @@ package invocation
 import (
 	"context"
 	"encoding/base64"
+	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/cloudwatchevents"
-	"github.com/aws/aws-sdk-go-v2/service/cloudwatchevents/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/golang/mock/gomock"
 	"github.com/pkg/errors"
 	"github.com/rclark/aws-basics/github-events/ingest/invocation/mock"
+	"github.com/rclark/aws-basics/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -92,12 +97,14 @@ func TestMissingSignature(t *testing.T) {
 
 	cw := mock.NewMockCanPutEvents(ctrl)
 	log := mock.NewMockLogger(ctrl)
+	metrics := mock.NewMockMetrics(ctrl)
 
 	handler := Handler{
-		Secret: "secret",
-		Bus:    "github-events",
-		Events: cw,
-		Logger: log,
+		Secret:  "secret",
+		Bus:     "github-events",
+		Events:  cw,
+		Logger:  log,
+		Metrics: metrics,
 	}
 
 	event := events.APIGatewayV2HTTPRequest{
@@ -115,6 +122,8 @@ func TestMissingSignature(t *testing.T) {
 	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
 	log.EXPECT().Print()
 
+	metrics.EXPECT().Count("SignatureRejected", float64(1))
+
 	res, err := handler.Run(ctx, event)
 	require.NoError(t, err, "should not error")
 	assert.Equal(t, 401, res.StatusCode, "should return 401")
@@ -128,12 +137,14 @@ func TestMismatchedSignature(t *testing.T) {
 
 	cw := mock.NewMockCanPutEvents(ctrl)
 	log := mock.NewMockLogger(ctrl)
+	metrics := mock.NewMockMetrics(ctrl)
 
 	handler := Handler{
-		Secret: "secret",
-		Bus:    "github-events",
-		Events: cw,
-		Logger: log,
+		Secret:  "secret",
+		Bus:     "github-events",
+		Events:  cw,
+		Logger:  log,
+		Metrics: metrics,
 	}
 
 	event := events.APIGatewayV2HTTPRequest{
@@ -153,8 +164,11 @@ func TestMismatchedSignature(t *testing.T) {
 	})
 	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
 	log.EXPECT().Set("SignatureFound", "sha256=from-github")
+	log.EXPECT().Set("SignatureAlgorithm", "sha256")
 	log.EXPECT().Print()
 
+	metrics.EXPECT().Count("SignatureRejected", float64(1))
+
 	res, err := handler.Run(ctx, event)
 	require.NoError(t, err, "should not error")
 	assert.Equal(t, 401, res.StatusCode, "should return 401")
@@ -193,6 +207,7 @@ func TestMissingEventTypeHeader(t *testing.T) {
 	})
 	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
 	log.EXPECT().Set("SignatureFound", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureAlgorithm", "sha256")
 	log.EXPECT().Print()
 
 	res, err := handler.Run(ctx, event)
@@ -208,12 +223,15 @@ func TestFailedPutEvents(t *testing.T) {
 
 	cw := mock.NewMockCanPutEvents(ctrl)
 	log := mock.NewMockLogger(ctrl)
+	metrics := mock.NewMockMetrics(ctrl)
 
 	handler := Handler{
-		Secret: "secret",
-		Bus:    "github-events",
-		Events: cw,
-		Logger: log,
+		Secret:  "secret",
+		Bus:     "github-events",
+		Events:  cw,
+		Logger:  log,
+		Metrics: metrics,
+		Retry:   utils.Retry{Backoff: func(int) time.Duration { return 0 }},
 	}
 
 	body := `{"now":"encoded"}`
@@ -235,10 +253,13 @@ func TestFailedPutEvents(t *testing.T) {
 	})
 	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
 	log.EXPECT().Set("SignatureFound", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureAlgorithm", "sha256")
 	log.EXPECT().Set("EventType", "push")
+	log.EXPECT().Set("TargetBus", "github-events").Times(3)
+	log.EXPECT().Set("Retries", "2")
 	log.EXPECT().Print()
 
-	cw.EXPECT().PutEvents(ctx, &cloudwatchevents.PutEventsInput{
+	cw.EXPECT().PutEvents(ctx, &eventbridge.PutEventsInput{
 		Entries: []types.PutEventsRequestEntry{{
 			Detail:       aws.String(body),
 			DetailType:   aws.String("push"),
@@ -246,9 +267,12 @@ func TestFailedPutEvents(t *testing.T) {
 			Source:       aws.String("github"),
 		}},
 	}).
-		DoAndReturn(func(context.Context, *cloudwatchevents.PutEventsInput, ...func(*cloudwatchevents.Options)) (*cloudwatchevents.PutEventsOutput, error) {
+		DoAndReturn(func(context.Context, *eventbridge.PutEventsInput, ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
 			return nil, errors.New("api call failed")
-		})
+		}).
+		Times(3)
+
+	metrics.EXPECT().Count("PutEventsFailed", float64(1))
 
 	res, err := handler.Run(ctx, event)
 	require.NoError(t, err, "should not error")
@@ -262,6 +286,151 @@ func TestSuccess(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
+	cw := mock.NewMockCanPutEvents(ctrl)
+	log := mock.NewMockLogger(ctrl)
+	metrics := mock.NewMockMetrics(ctrl)
+
+	handler := Handler{
+		Secret:  "secret",
+		Bus:     "github-events",
+		Events:  cw,
+		Logger:  log,
+		Metrics: metrics,
+	}
+
+	body := `{"now":"encoded"}`
+	event := events.APIGatewayV2HTTPRequest{
+		IsBase64Encoded: true,
+		Body:            base64.RawStdEncoding.EncodeToString([]byte(body)),
+		Headers: map[string]string{
+			"x-hub-signature-256": "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb",
+			"x-github-event":      "Push",
+			"x-github-delivery":   "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+		},
+	}
+
+	log.EXPECT().Clear()
+	log.EXPECT().Set("Delivery", "1324d090-1319-4fe5-8a9f-32dd44b238fd")
+	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureFound", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureAlgorithm", "sha256")
+	log.EXPECT().Set("EventType", "push")
+	log.EXPECT().Set("TargetBus", "github-events")
+	log.EXPECT().Print()
+
+	cw.EXPECT().PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []types.PutEventsRequestEntry{{
+			Detail:       aws.String(body),
+			DetailType:   aws.String("push"),
+			EventBusName: aws.String("github-events"),
+			Source:       aws.String("github"),
+		}},
+	})
+
+	metrics.EXPECT().Count("Accepted", float64(1))
+
+	res, err := handler.Run(ctx, event)
+	require.NoError(t, err, "should not error")
+	assert.Equal(t, 201, res.StatusCode, "should return 201")
+}
+
+func TestLegacySignature(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cw := mock.NewMockCanPutEvents(ctrl)
+	log := mock.NewMockLogger(ctrl)
+
+	handler := Handler{
+		Secret: "secret",
+		Bus:    "github-events",
+		Events: cw,
+		Logger: log,
+	}
+
+	body := `{"now":"encoded"}`
+	event := events.APIGatewayV2HTTPRequest{
+		IsBase64Encoded: true,
+		Body:            base64.RawStdEncoding.EncodeToString([]byte(body)),
+		Headers: map[string]string{
+			"x-hub-signature":   "sha1=fed6ea5a7aad38cb681e50c7b63d619115f3988f",
+			"x-github-event":    "Push",
+			"x-github-delivery": "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+		},
+	}
+
+	log.EXPECT().Clear()
+	log.EXPECT().Set("Delivery", "1324d090-1319-4fe5-8a9f-32dd44b238fd")
+	log.EXPECT().Set("SignatureExpected", "sha1=fed6ea5a7aad38cb681e50c7b63d619115f3988f")
+	log.EXPECT().Set("SignatureFound", "sha1=fed6ea5a7aad38cb681e50c7b63d619115f3988f")
+	log.EXPECT().Set("SignatureAlgorithm", "sha1")
+	log.EXPECT().Set("EventType", "push")
+	log.EXPECT().Set("TargetBus", "github-events")
+	log.EXPECT().Print()
+
+	cw.EXPECT().PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []types.PutEventsRequestEntry{{
+			Detail:       aws.String(body),
+			DetailType:   aws.String("push"),
+			EventBusName: aws.String("github-events"),
+			Source:       aws.String("github"),
+		}},
+	})
+
+	res, err := handler.Run(ctx, event)
+	require.NoError(t, err, "should not error")
+	assert.Equal(t, 201, res.StatusCode, "should return 201")
+}
+
+func TestPrefersSHA256Signature(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cw := mock.NewMockCanPutEvents(ctrl)
+	log := mock.NewMockLogger(ctrl)
+
+	handler := Handler{
+		Secret: "secret",
+		Bus:    "github-events",
+		Events: cw,
+		Logger: log,
+	}
+
+	body := `{"now":"encoded"}`
+	event := events.APIGatewayV2HTTPRequest{
+		IsBase64Encoded: true,
+		Body:            base64.RawStdEncoding.EncodeToString([]byte(body)),
+		Headers: map[string]string{
+			"x-hub-signature-256": "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb",
+			"x-hub-signature":     "sha1=not-checked",
+			"x-github-event":      "Push",
+			"x-github-delivery":   "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+		},
+	}
+
+	log.EXPECT().Clear()
+	log.EXPECT().Set("Delivery", "1324d090-1319-4fe5-8a9f-32dd44b238fd")
+	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureFound", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureAlgorithm", "sha256")
+	log.EXPECT().Set("EventType", "push")
+	log.EXPECT().Set("TargetBus", "github-events")
+	log.EXPECT().Print()
+
+	cw.EXPECT().PutEvents(ctx, gomock.Any())
+
+	res, err := handler.Run(ctx, event)
+	require.NoError(t, err, "should not error")
+	assert.Equal(t, 201, res.StatusCode, "should return 201")
+}
+
+func TestMixedCaseHeaders(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
 	cw := mock.NewMockCanPutEvents(ctrl)
 	log := mock.NewMockLogger(ctrl)
 
@@ -276,6 +445,825 @@ func TestSuccess(t *testing.T) {
 	event := events.APIGatewayV2HTTPRequest{
 		IsBase64Encoded: true,
 		Body:            base64.RawStdEncoding.EncodeToString([]byte(body)),
+		Headers: map[string]string{
+			"X-Hub-Signature-256": "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb",
+			"X-GitHub-Event":      "Push",
+			"X-GitHub-Delivery":   "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+		},
+	}
+
+	log.EXPECT().Clear()
+	log.EXPECT().Set("Delivery", "1324d090-1319-4fe5-8a9f-32dd44b238fd")
+	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureFound", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureAlgorithm", "sha256")
+	log.EXPECT().Set("EventType", "push")
+	log.EXPECT().Set("TargetBus", "github-events")
+	log.EXPECT().Print()
+
+	cw.EXPECT().PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []types.PutEventsRequestEntry{{
+			Detail:       aws.String(body),
+			DetailType:   aws.String("push"),
+			EventBusName: aws.String("github-events"),
+			Source:       aws.String("github"),
+		}},
+	})
+
+	res, err := handler.Run(ctx, event)
+	require.NoError(t, err, "should not error")
+	assert.Equal(t, 201, res.StatusCode, "should return 201")
+}
+
+func TestSkippedEventType(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cw := mock.NewMockCanPutEvents(ctrl)
+	log := mock.NewMockLogger(ctrl)
+
+	handler := Handler{
+		Secret:        "secret",
+		Bus:           "github-events",
+		Events:        cw,
+		Logger:        log,
+		AllowedEvents: []string{"push", "pull_request"},
+	}
+
+	event := events.APIGatewayV2HTTPRequest{
+		IsBase64Encoded: true,
+		Body:            base64.RawStdEncoding.EncodeToString([]byte(`{"now":"encoded"}`)),
+		Headers: map[string]string{
+			"x-hub-signature-256": "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb",
+			"x-github-event":      "Issues",
+			"x-github-delivery":   "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+		},
+	}
+
+	log.EXPECT().Clear()
+	log.EXPECT().Set("Delivery", "1324d090-1319-4fe5-8a9f-32dd44b238fd")
+	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureFound", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureAlgorithm", "sha256")
+	log.EXPECT().Set("EventType", "issues")
+	log.EXPECT().Set("Skipped", "issues")
+	log.EXPECT().Print()
+
+	res, err := handler.Run(ctx, event)
+	require.NoError(t, err, "should not error")
+	assert.Equal(t, 204, res.StatusCode, "should return 204")
+}
+
+func TestAllowedEventType(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cw := mock.NewMockCanPutEvents(ctrl)
+	log := mock.NewMockLogger(ctrl)
+
+	handler := Handler{
+		Secret:        "secret",
+		Bus:           "github-events",
+		Events:        cw,
+		Logger:        log,
+		AllowedEvents: []string{"push", "pull_request"},
+	}
+
+	body := `{"now":"encoded"}`
+	event := events.APIGatewayV2HTTPRequest{
+		IsBase64Encoded: true,
+		Body:            base64.RawStdEncoding.EncodeToString([]byte(body)),
+		Headers: map[string]string{
+			"x-hub-signature-256": "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb",
+			"x-github-event":      "Push",
+			"x-github-delivery":   "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+		},
+	}
+
+	log.EXPECT().Clear()
+	log.EXPECT().Set("Delivery", "1324d090-1319-4fe5-8a9f-32dd44b238fd")
+	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureFound", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureAlgorithm", "sha256")
+	log.EXPECT().Set("EventType", "push")
+	log.EXPECT().Set("TargetBus", "github-events")
+	log.EXPECT().Print()
+
+	cw.EXPECT().PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []types.PutEventsRequestEntry{{
+			Detail:       aws.String(body),
+			DetailType:   aws.String("push"),
+			EventBusName: aws.String("github-events"),
+			Source:       aws.String("github"),
+		}},
+	})
+
+	res, err := handler.Run(ctx, event)
+	require.NoError(t, err, "should not error")
+	assert.Equal(t, 201, res.StatusCode, "should return 201")
+}
+
+func TestRoutedBus(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cw := mock.NewMockCanPutEvents(ctrl)
+	log := mock.NewMockLogger(ctrl)
+
+	handler := Handler{
+		Secret:     "secret",
+		Bus:        "github-events",
+		Events:     cw,
+		Logger:     log,
+		BusRouting: map[string]string{"Deployment": "deployment-events"},
+	}
+
+	body := `{"now":"encoded"}`
+	event := events.APIGatewayV2HTTPRequest{
+		IsBase64Encoded: true,
+		Body:            base64.RawStdEncoding.EncodeToString([]byte(body)),
+		Headers: map[string]string{
+			"x-hub-signature-256": "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb",
+			"x-github-event":      "Deployment",
+			"x-github-delivery":   "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+		},
+	}
+
+	log.EXPECT().Clear()
+	log.EXPECT().Set("Delivery", "1324d090-1319-4fe5-8a9f-32dd44b238fd")
+	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureFound", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureAlgorithm", "sha256")
+	log.EXPECT().Set("EventType", "deployment")
+	log.EXPECT().Set("TargetBus", "deployment-events")
+	log.EXPECT().Print()
+
+	cw.EXPECT().PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []types.PutEventsRequestEntry{{
+			Detail:       aws.String(body),
+			DetailType:   aws.String("deployment"),
+			EventBusName: aws.String("deployment-events"),
+			Source:       aws.String("github"),
+		}},
+	})
+
+	res, err := handler.Run(ctx, event)
+	require.NoError(t, err, "should not error")
+	assert.Equal(t, 201, res.StatusCode, "should return 201")
+}
+
+func TestFallbackBus(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cw := mock.NewMockCanPutEvents(ctrl)
+	log := mock.NewMockLogger(ctrl)
+
+	handler := Handler{
+		Secret:     "secret",
+		Bus:        "github-events",
+		Events:     cw,
+		Logger:     log,
+		BusRouting: map[string]string{"deployment": "deployment-events"},
+	}
+
+	body := `{"now":"encoded"}`
+	event := events.APIGatewayV2HTTPRequest{
+		IsBase64Encoded: true,
+		Body:            base64.RawStdEncoding.EncodeToString([]byte(body)),
+		Headers: map[string]string{
+			"x-hub-signature-256": "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb",
+			"x-github-event":      "Push",
+			"x-github-delivery":   "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+		},
+	}
+
+	log.EXPECT().Clear()
+	log.EXPECT().Set("Delivery", "1324d090-1319-4fe5-8a9f-32dd44b238fd")
+	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureFound", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureAlgorithm", "sha256")
+	log.EXPECT().Set("EventType", "push")
+	log.EXPECT().Set("TargetBus", "github-events")
+	log.EXPECT().Print()
+
+	cw.EXPECT().PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []types.PutEventsRequestEntry{{
+			Detail:       aws.String(body),
+			DetailType:   aws.String("push"),
+			EventBusName: aws.String("github-events"),
+			Source:       aws.String("github"),
+		}},
+	})
+
+	res, err := handler.Run(ctx, event)
+	require.NoError(t, err, "should not error")
+	assert.Equal(t, 201, res.StatusCode, "should return 201")
+}
+
+func TestQueueTarget(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	queue := mock.NewMockCanSendMessage(ctrl)
+	log := mock.NewMockLogger(ctrl)
+
+	handler := Handler{
+		Secret: "secret",
+		Logger: log,
+		Target: &QueueTarget{
+			Queue:  queue,
+			URL:    "https://sqs.us-east-1.amazonaws.com/123456789012/github-events",
+			Logger: log,
+		},
+	}
+
+	body := `{"now":"encoded"}`
+	event := events.APIGatewayV2HTTPRequest{
+		IsBase64Encoded: true,
+		Body:            base64.RawStdEncoding.EncodeToString([]byte(body)),
+		Headers: map[string]string{
+			"x-hub-signature-256": "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb",
+			"x-github-event":      "Push",
+			"x-github-delivery":   "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+		},
+	}
+
+	log.EXPECT().Clear()
+	log.EXPECT().Set("Delivery", "1324d090-1319-4fe5-8a9f-32dd44b238fd")
+	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureFound", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureAlgorithm", "sha256")
+	log.EXPECT().Set("EventType", "push")
+	log.EXPECT().Set("TargetQueue", "https://sqs.us-east-1.amazonaws.com/123456789012/github-events")
+	log.EXPECT().Print()
+
+	queue.EXPECT().SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/github-events"),
+		MessageBody: aws.String(body),
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			"githubEvent": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String("push"),
+			},
+		},
+	})
+
+	res, err := handler.Run(ctx, event)
+	require.NoError(t, err, "should not error")
+	assert.Equal(t, 201, res.StatusCode, "should return 201")
+}
+
+func TestFailedSendMessage(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	queue := mock.NewMockCanSendMessage(ctrl)
+	log := mock.NewMockLogger(ctrl)
+
+	handler := Handler{
+		Secret: "secret",
+		Logger: log,
+		Retry:  utils.Retry{Backoff: func(int) time.Duration { return 0 }},
+		Target: &QueueTarget{
+			Queue:  queue,
+			URL:    "https://sqs.us-east-1.amazonaws.com/123456789012/github-events",
+			Logger: log,
+		},
+	}
+
+	event := events.APIGatewayV2HTTPRequest{
+		IsBase64Encoded: true,
+		Body:            base64.RawStdEncoding.EncodeToString([]byte(`{"now":"encoded"}`)),
+		Headers: map[string]string{
+			"x-hub-signature-256": "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb",
+			"x-github-event":      "Push",
+			"x-github-delivery":   "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+		},
+	}
+
+	var logged string
+	log.EXPECT().Clear()
+	log.EXPECT().Set("Delivery", "1324d090-1319-4fe5-8a9f-32dd44b238fd")
+	log.EXPECT().Set("Error", gomock.Any()).DoAndReturn(func(key string, val string) {
+		logged = val
+	})
+	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureFound", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureAlgorithm", "sha256")
+	log.EXPECT().Set("EventType", "push")
+	log.EXPECT().Set("TargetQueue", "https://sqs.us-east-1.amazonaws.com/123456789012/github-events").Times(3)
+	log.EXPECT().Set("Retries", "2")
+	log.EXPECT().Print()
+
+	queue.EXPECT().SendMessage(ctx, gomock.Any()).Return(nil, errors.New("api call failed")).Times(3)
+
+	res, err := handler.Run(ctx, event)
+	require.NoError(t, err, "should not error")
+	assert.Equal(t, 500, res.StatusCode, "should return 500")
+	assert.True(t, strings.Contains(logged, "failed SendMessage API call"), "expected log message")
+	assert.True(t, strings.Contains(logged, "api call failed"), "logs underlying API failure")
+}
+
+func TestWrapEnvelope(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cw := mock.NewMockCanPutEvents(ctrl)
+	log := mock.NewMockLogger(ctrl)
+
+	handler := Handler{
+		Secret:       "secret",
+		Bus:          "github-events",
+		Events:       cw,
+		Logger:       log,
+		WrapEnvelope: true,
+	}
+
+	event := events.APIGatewayV2HTTPRequest{
+		IsBase64Encoded: true,
+		Body:            base64.RawStdEncoding.EncodeToString([]byte(`{"now":"encoded"}`)),
+		Headers: map[string]string{
+			"x-hub-signature-256": "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb",
+			"x-github-event":      "Push",
+			"x-github-delivery":   "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+		},
+	}
+
+	log.EXPECT().Clear()
+	log.EXPECT().Set("Delivery", "1324d090-1319-4fe5-8a9f-32dd44b238fd")
+	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureFound", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureAlgorithm", "sha256")
+	log.EXPECT().Set("EventType", "push")
+	log.EXPECT().Set("TargetBus", "github-events")
+	log.EXPECT().Print()
+
+	cw.EXPECT().PutEvents(ctx, gomock.Any()).
+		DoAndReturn(func(ctx context.Context, input *eventbridge.PutEventsInput, opts ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+			require.Len(t, input.Entries, 1, "sends one event")
+			assert.JSONEq(t, `{
+				"delivery": "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+				"event": "push",
+				"payload": {"now":"encoded"}
+			}`, *input.Entries[0].Detail, "sends payload in an envelope")
+			assert.Equal(t, "push", *input.Entries[0].DetailType, "sets detail type")
+			return &eventbridge.PutEventsOutput{}, nil
+		})
+
+	res, err := handler.Run(ctx, event)
+	require.NoError(t, err, "should not error")
+	assert.Equal(t, 201, res.StatusCode, "should return 201")
+}
+
+func TestPayloadTooLarge(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cw := mock.NewMockCanPutEvents(ctrl)
+	log := mock.NewMockLogger(ctrl)
+
+	handler := Handler{
+		Secret: "secret",
+		Bus:    "github-events",
+		Events: cw,
+		Logger: log,
+	}
+
+	body := fmt.Sprintf(`{"large":"%s"}`, strings.Repeat("a", DefaultMaxPayloadBytes))
+	event := events.APIGatewayV2HTTPRequest{
+		IsBase64Encoded: true,
+		Body:            base64.RawStdEncoding.EncodeToString([]byte(body)),
+		Headers: map[string]string{
+			"x-hub-signature-256": "sha256=not-checked",
+			"x-github-event":      "Push",
+			"x-github-delivery":   "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+		},
+	}
+
+	var logged string
+	log.EXPECT().Clear()
+	log.EXPECT().Set("Delivery", "1324d090-1319-4fe5-8a9f-32dd44b238fd")
+	log.EXPECT().Set("Error", gomock.Any()).DoAndReturn(func(key string, val string) {
+		logged = val
+	})
+	log.EXPECT().Print()
+
+	cw.EXPECT().PutEvents(gomock.Any(), gomock.Any()).Times(0)
+
+	res, err := handler.Run(ctx, event)
+	require.NoError(t, err, "should not error")
+	assert.Equal(t, 413, res.StatusCode, "should return 413")
+	assert.True(t, strings.HasPrefix(logged, "PayloadTooLarge: "), "expected log message")
+}
+
+func TestWrappedPayloadTooLarge(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cw := mock.NewMockCanPutEvents(ctrl)
+	log := mock.NewMockLogger(ctrl)
+
+	// The payload fits within the limit, but not once it is wrapped.
+	body := `{"now":"encoded"}`
+	handler := Handler{
+		Secret:          "secret",
+		Bus:             "github-events",
+		Events:          cw,
+		Logger:          log,
+		WrapEnvelope:    true,
+		MaxPayloadBytes: len(body) + 10,
+	}
+
+	event := events.APIGatewayV2HTTPRequest{
+		IsBase64Encoded: true,
+		Body:            base64.RawStdEncoding.EncodeToString([]byte(body)),
+		Headers: map[string]string{
+			"x-hub-signature-256": "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb",
+			"x-github-event":      "Push",
+			"x-github-delivery":   "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+		},
+	}
+
+	var logged string
+	log.EXPECT().Clear()
+	log.EXPECT().Set("Delivery", "1324d090-1319-4fe5-8a9f-32dd44b238fd")
+	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureFound", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureAlgorithm", "sha256")
+	log.EXPECT().Set("EventType", "push")
+	log.EXPECT().Set("Error", gomock.Any()).DoAndReturn(func(key string, val string) {
+		logged = val
+	})
+	log.EXPECT().Print()
+
+	cw.EXPECT().PutEvents(gomock.Any(), gomock.Any()).Times(0)
+
+	res, err := handler.Run(ctx, event)
+	require.NoError(t, err, "should not error")
+	assert.Equal(t, 413, res.StatusCode, "should return 413")
+	assert.True(t, strings.HasPrefix(logged, "PayloadTooLarge: "), "expected log message")
+}
+
+func TestPing(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cw := mock.NewMockCanPutEvents(ctrl)
+	log := mock.NewMockLogger(ctrl)
+
+	handler := Handler{
+		Secret: "secret",
+		Bus:    "github-events",
+		Events: cw,
+		Logger: log,
+	}
+
+	event := events.APIGatewayV2HTTPRequest{
+		IsBase64Encoded: true,
+		Body:            base64.RawStdEncoding.EncodeToString([]byte(`{"now":"encoded"}`)),
+		Headers: map[string]string{
+			"x-hub-signature-256": "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb",
+			"x-github-event":      "ping",
+			"x-github-delivery":   "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+		},
+	}
+
+	log.EXPECT().Clear()
+	log.EXPECT().Set("Delivery", "1324d090-1319-4fe5-8a9f-32dd44b238fd")
+	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureFound", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureAlgorithm", "sha256")
+	log.EXPECT().Set("EventType", "ping")
+	log.EXPECT().Set("Pong", "true")
+	log.EXPECT().Print()
+
+	cw.EXPECT().PutEvents(gomock.Any(), gomock.Any()).Times(0)
+
+	res, err := handler.Run(ctx, event)
+	require.NoError(t, err, "should not error")
+	assert.Equal(t, 200, res.StatusCode, "should return 200")
+}
+
+func TestUnsignedPing(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cw := mock.NewMockCanPutEvents(ctrl)
+	log := mock.NewMockLogger(ctrl)
+
+	handler := Handler{
+		Secret: "secret",
+		Bus:    "github-events",
+		Events: cw,
+		Logger: log,
+	}
+
+	event := events.APIGatewayV2HTTPRequest{
+		IsBase64Encoded: true,
+		Body:            base64.RawStdEncoding.EncodeToString([]byte(`{"now":"encoded"}`)),
+		Headers: map[string]string{
+			"x-github-event":    "ping",
+			"x-github-delivery": "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+		},
+	}
+
+	var logged string
+	log.EXPECT().Clear()
+	log.EXPECT().Set("Delivery", "1324d090-1319-4fe5-8a9f-32dd44b238fd")
+	log.EXPECT().Set("Error", gomock.Any()).DoAndReturn(func(key string, val string) {
+		logged = val
+	})
+	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Print()
+
+	res, err := handler.Run(ctx, event)
+	require.NoError(t, err, "should not error")
+	assert.Equal(t, 401, res.StatusCode, "should return 401")
+	assert.True(t, strings.Contains(logged, "no signature header"), "expected log message")
+}
+
+func TestRetriedPutEvents(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cw := mock.NewMockCanPutEvents(ctrl)
+	log := mock.NewMockLogger(ctrl)
+
+	var backoffs []int
+	handler := Handler{
+		Secret: "secret",
+		Bus:    "github-events",
+		Events: cw,
+		Logger: log,
+		Retry: utils.Retry{Backoff: func(retry int) time.Duration {
+			backoffs = append(backoffs, retry)
+			return 0
+		}},
+	}
+
+	body := `{"now":"encoded"}`
+	event := events.APIGatewayV2HTTPRequest{
+		IsBase64Encoded: true,
+		Body:            base64.RawStdEncoding.EncodeToString([]byte(body)),
+		Headers: map[string]string{
+			"x-hub-signature-256": "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb",
+			"x-github-event":      "Push",
+			"x-github-delivery":   "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+		},
+	}
+
+	log.EXPECT().Clear()
+	log.EXPECT().Set("Delivery", "1324d090-1319-4fe5-8a9f-32dd44b238fd")
+	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureFound", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureAlgorithm", "sha256")
+	log.EXPECT().Set("EventType", "push")
+	log.EXPECT().Set("TargetBus", "github-events").Times(2)
+	log.EXPECT().Set("Retries", "1")
+	log.EXPECT().Print()
+
+	gomock.InOrder(
+		cw.EXPECT().PutEvents(ctx, gomock.Any()).Return(nil, errors.New("throttled")),
+		cw.EXPECT().PutEvents(ctx, gomock.Any()).Return(&eventbridge.PutEventsOutput{}, nil),
+	)
+
+	res, err := handler.Run(ctx, event)
+	require.NoError(t, err, "should not error")
+	assert.Equal(t, 201, res.StatusCode, "should return 201")
+	assert.Equal(t, []int{1}, backoffs, "backs off once before retrying")
+}
+
+func TestFailedPutEventsEntry(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cw := mock.NewMockCanPutEvents(ctrl)
+	log := mock.NewMockLogger(ctrl)
+
+	handler := Handler{
+		Secret: "secret",
+		Bus:    "github-events",
+		Events: cw,
+		Logger: log,
+		Retry:  utils.Retry{MaxRetries: -1},
+	}
+
+	event := events.APIGatewayV2HTTPRequest{
+		IsBase64Encoded: true,
+		Body:            base64.RawStdEncoding.EncodeToString([]byte(`{"now":"encoded"}`)),
+		Headers: map[string]string{
+			"x-hub-signature-256": "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb",
+			"x-github-event":      "Push",
+			"x-github-delivery":   "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+		},
+	}
+
+	var logged string
+	log.EXPECT().Clear()
+	log.EXPECT().Set("Delivery", "1324d090-1319-4fe5-8a9f-32dd44b238fd")
+	log.EXPECT().Set("Error", gomock.Any()).DoAndReturn(func(key string, val string) {
+		logged = val
+	})
+	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureFound", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureAlgorithm", "sha256")
+	log.EXPECT().Set("EventType", "push")
+	log.EXPECT().Set("TargetBus", "github-events")
+	log.EXPECT().Print()
+
+	cw.EXPECT().PutEvents(ctx, gomock.Any()).Return(&eventbridge.PutEventsOutput{
+		FailedEntryCount: 1,
+		Entries: []types.PutEventsResultEntry{{
+			ErrorCode:    aws.String("InternalFailure"),
+			ErrorMessage: aws.String("something went wrong"),
+		}},
+	}, nil)
+
+	res, err := handler.Run(ctx, event)
+	require.NoError(t, err, "should not error")
+	assert.Equal(t, 500, res.StatusCode, "should return 500")
+	assert.True(t, strings.Contains(logged, "PutEvents failed for 1 entries"), "expected log message")
+	assert.True(t, strings.Contains(logged, "InternalFailure: something went wrong"), "logs entry error")
+}
+
+func TestRunV1(t *testing.T) {
+	signed := base64.RawStdEncoding.EncodeToString([]byte(`{"now":"encoded"}`))
+
+	tests := []struct {
+		name    string
+		event   events.APIGatewayProxyRequest
+		putErr  error
+		status  int
+		logged  string
+		putCall bool
+	}{
+		{
+			name: "missing delivery header",
+			event: events.APIGatewayProxyRequest{
+				Body: `{"not":"encoded"}`,
+			},
+			status: 401,
+			logged: "missing delivery header",
+		},
+		{
+			name: "invalid event body",
+			event: events.APIGatewayProxyRequest{
+				IsBase64Encoded: true,
+				Body:            `{"not":"encoded"}`,
+				Headers:         map[string]string{"X-GitHub-Delivery": "1324d090-1319-4fe5-8a9f-32dd44b238fd"},
+			},
+			status: 401,
+			logged: "failed to decode request body",
+		},
+		{
+			name: "missing signature",
+			event: events.APIGatewayProxyRequest{
+				IsBase64Encoded: true,
+				Body:            signed,
+				Headers:         map[string]string{"X-GitHub-Delivery": "1324d090-1319-4fe5-8a9f-32dd44b238fd"},
+			},
+			status: 401,
+			logged: "no signature header",
+		},
+		{
+			name: "mismatched signature",
+			event: events.APIGatewayProxyRequest{
+				IsBase64Encoded: true,
+				Body:            signed,
+				Headers: map[string]string{
+					"X-Hub-Signature-256": "sha256=from-github",
+					"X-GitHub-Delivery":   "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+				},
+			},
+			status: 401,
+			logged: "signature mismatch",
+		},
+		{
+			name: "missing event type header",
+			event: events.APIGatewayProxyRequest{
+				IsBase64Encoded: true,
+				Body:            signed,
+				Headers: map[string]string{
+					"X-Hub-Signature-256": "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb",
+					"X-GitHub-Delivery":   "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+				},
+			},
+			status: 401,
+			logged: "missing event type header",
+		},
+		{
+			name: "failed PutEvents",
+			event: events.APIGatewayProxyRequest{
+				IsBase64Encoded: true,
+				Body:            signed,
+				Headers: map[string]string{
+					"X-Hub-Signature-256": "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb",
+					"X-GitHub-Event":      "Push",
+					"X-GitHub-Delivery":   "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+				},
+			},
+			putErr:  errors.New("api call failed"),
+			putCall: true,
+			status:  500,
+			logged:  "failed PutEvents API call",
+		},
+		{
+			name: "success",
+			event: events.APIGatewayProxyRequest{
+				IsBase64Encoded: true,
+				Body:            signed,
+				Headers: map[string]string{
+					"X-Hub-Signature-256": "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb",
+					"X-GitHub-Event":      "Push",
+					"X-GitHub-Delivery":   "1324d090-1319-4fe5-8a9f-32dd44b238fd",
+				},
+			},
+			putCall: true,
+			status:  201,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			cw := mock.NewMockCanPutEvents(ctrl)
+			log := mock.NewMockLogger(ctrl)
+
+			handler := Handler{
+				Secret: "secret",
+				Bus:    "github-events",
+				Events: cw,
+				Logger: log,
+				Retry:  utils.Retry{MaxRetries: -1},
+			}
+
+			var logged string
+			log.EXPECT().Clear()
+			log.EXPECT().Set(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(key string, val string) {
+				if key == "Error" {
+					logged = val
+				}
+			})
+			log.EXPECT().Print()
+
+			if test.putCall {
+				cw.EXPECT().PutEvents(ctx, &eventbridge.PutEventsInput{
+					Entries: []types.PutEventsRequestEntry{{
+						Detail:       aws.String(`{"now":"encoded"}`),
+						DetailType:   aws.String("push"),
+						EventBusName: aws.String("github-events"),
+						Source:       aws.String("github"),
+					}},
+				}).Return(&eventbridge.PutEventsOutput{}, test.putErr)
+			}
+
+			res, err := handler.RunV1(ctx, test.event)
+			require.NoError(t, err, "should not error")
+			assert.Equal(t, test.status, res.StatusCode, "expected status code")
+			assert.True(t, strings.Contains(logged, test.logged), "expected log message")
+		})
+	}
+}
+
+func TestPaddedBase64Body(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cw := mock.NewMockCanPutEvents(ctrl)
+	log := mock.NewMockLogger(ctrl)
+
+	handler := Handler{
+		Secret: "secret",
+		Bus:    "github-events",
+		Events: cw,
+		Logger: log,
+	}
+
+	body := `{"now":"encoded"}`
+	encoded := base64.StdEncoding.EncodeToString([]byte(body))
+	require.True(t, strings.HasSuffix(encoded, "="), "body should be padded")
+
+	event := events.APIGatewayV2HTTPRequest{
+		IsBase64Encoded: true,
+		Body:            encoded,
 		Headers: map[string]string{
 			"x-hub-signature-256": "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb",
 			"x-github-event":      "Push",
@@ -287,10 +1275,12 @@ func TestSuccess(t *testing.T) {
 	log.EXPECT().Set("Delivery", "1324d090-1319-4fe5-8a9f-32dd44b238fd")
 	log.EXPECT().Set("SignatureExpected", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
 	log.EXPECT().Set("SignatureFound", "sha256=b4d09a57d222aeefc11428e84e7be1eb8868852805ceded48eb9749f5fd8b1bb")
+	log.EXPECT().Set("SignatureAlgorithm", "sha256")
 	log.EXPECT().Set("EventType", "push")
+	log.EXPECT().Set("TargetBus", "github-events")
 	log.EXPECT().Print()
 
-	cw.EXPECT().PutEvents(ctx, &cloudwatchevents.PutEventsInput{
+	cw.EXPECT().PutEvents(ctx, &eventbridge.PutEventsInput{
 		Entries: []types.PutEventsRequestEntry{{
 			Detail:       aws.String(body),
 			DetailType:   aws.String("push"),
@@ -6,7 +6,7 @@ package mock
 
 import (
 	context "context"
-	cloudwatchevents "github.com/aws/aws-sdk-go-v2/service/cloudwatchevents"
+	eventbridge "github.com/aws/aws-sdk-go-v2/service/eventbridge"
 	gomock "github.com/golang/mock/gomock"
 	reflect "reflect"
 )
@@ -70,6 +70,41 @@ func (mr *MockLoggerMockRecorder) Print() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Print", reflect.TypeOf((*MockLogger)(nil).Print))
 }
 
+// MockMetrics is a mock of Metrics interface
+type MockMetrics struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricsMockRecorder
+}
+
+// MockMetricsMockRecorder is the mock recorder for MockMetrics
+type MockMetricsMockRecorder struct {
+	mock *MockMetrics
+}
+
+// NewMockMetrics creates a new mock instance
+func NewMockMetrics(ctrl *gomock.Controller) *MockMetrics {
+	mock := &MockMetrics{ctrl: ctrl}
+	mock.recorder = &MockMetricsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockMetrics) EXPECT() *MockMetricsMockRecorder {
+	return m.recorder
+}
+
+// Count mocks base method
+func (m *MockMetrics) Count(name string, value float64) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Count", name, value)
+}
+
+// Count indicates an expected call of Count
+func (mr *MockMetricsMockRecorder) Count(name, value interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Count", reflect.TypeOf((*MockMetrics)(nil).Count), name, value)
+}
+
 // MockCanPutEvents is a mock of CanPutEvents interface
 type MockCanPutEvents struct {
 	ctrl     *gomock.Controller
@@ -94,14 +129,14 @@ func (m *MockCanPutEvents) EXPECT() *MockCanPutEventsMockRecorder {
 }
 
 // PutEvents mocks base method
-func (m *MockCanPutEvents) PutEvents(ctx context.Context, params *cloudwatchevents.PutEventsInput, optFns ...func(*cloudwatchevents.Options)) (*cloudwatchevents.PutEventsOutput, error) {
+func (m *MockCanPutEvents) PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
 	m.ctrl.T.Helper()
 	varargs := []interface{}{ctx, params}
 	for _, a := range optFns {
 		varargs = append(varargs, a)
 	}
 	ret := m.ctrl.Call(m, "PutEvents", varargs...)
-	ret0, _ := ret[0].(*cloudwatchevents.PutEventsOutput)
+	ret0, _ := ret[0].(*eventbridge.PutEventsOutput)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -0,0 +1,92 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: ./target.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	context "context"
+	sqs "github.com/aws/aws-sdk-go-v2/service/sqs"
+	gomock "github.com/golang/mock/gomock"
+	reflect "reflect"
+)
+
+// MockCanSendMessage is a mock of CanSendMessage interface
+type MockCanSendMessage struct {
+	ctrl     *gomock.Controller
+	recorder *MockCanSendMessageMockRecorder
+}
+
+// MockCanSendMessageMockRecorder is the mock recorder for MockCanSendMessage
+type MockCanSendMessageMockRecorder struct {
+	mock *MockCanSendMessage
+}
+
+// NewMockCanSendMessage creates a new mock instance
+func NewMockCanSendMessage(ctrl *gomock.Controller) *MockCanSendMessage {
+	mock := &MockCanSendMessage{ctrl: ctrl}
+	mock.recorder = &MockCanSendMessageMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockCanSendMessage) EXPECT() *MockCanSendMessageMockRecorder {
+	return m.recorder
+}
+
+// SendMessage mocks base method
+func (m *MockCanSendMessage) SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, params}
+	for _, a := range optFns {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "SendMessage", varargs...)
+	ret0, _ := ret[0].(*sqs.SendMessageOutput)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SendMessage indicates an expected call of SendMessage
+func (mr *MockCanSendMessageMockRecorder) SendMessage(ctx, params interface{}, optFns ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, params}, optFns...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMessage", reflect.TypeOf((*MockCanSendMessage)(nil).SendMessage), varargs...)
+}
+
+// MockTarget is a mock of Target interface
+type MockTarget struct {
+	ctrl     *gomock.Controller
+	recorder *MockTargetMockRecorder
+}
+
+// MockTargetMockRecorder is the mock recorder for MockTarget
+type MockTargetMockRecorder struct {
+	mock *MockTarget
+}
+
+// NewMockTarget creates a new mock instance
+func NewMockTarget(ctrl *gomock.Controller) *MockTarget {
+	mock := &MockTarget{ctrl: ctrl}
+	mock.recorder = &MockTargetMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockTarget) EXPECT() *MockTargetMockRecorder {
+	return m.recorder
+}
+
+// Send mocks base method
+func (m *MockTarget) Send(ctx context.Context, eventType string, body []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Send", ctx, eventType, body)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Send indicates an expected call of Send
+func (mr *MockTargetMockRecorder) Send(ctx, eventType, body interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Send", reflect.TypeOf((*MockTarget)(nil).Send), ctx, eventType, body)
+}
@@ -0,0 +1,102 @@
+package invocation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/pkg/errors"
+)
+
+//go:generate mockgen -source ./target.go -package mock -destination ./mock/target.go
+
+// CanSendMessage represents the SQS SendMessage API method.
+type CanSendMessage interface {
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
+
+// Target delivers a validated GitHub event to the system that consumes it.
+type Target interface {
+	Send(ctx context.Context, eventType string, body []byte) error
+}
+
+// EventBusTarget delivers events to EventBridge, on the bus that BusRouting
+// assigns to the event type, or on Bus by default. BusRouting's event types are
+// matched regardless of case. The chosen bus is recorded in the log as
+// TargetBus.
+type EventBusTarget struct {
+	Events     CanPutEvents
+	Bus        string
+	BusRouting map[string]string
+	Logger     Logger
+}
+
+// Send produces a single EventBridge event representing the GitHub event. The
+// event is considered a failure if the PutEvents response reports that it was
+// not ingested.
+func (t *EventBusTarget) Send(ctx context.Context, eventType string, body []byte) error {
+	bus := t.Bus
+	for routedType, routed := range t.BusRouting {
+		if strings.EqualFold(routedType, eventType) {
+			bus = routed
+			break
+		}
+	}
+	t.Logger.Set("TargetBus", bus)
+
+	res, err := t.Events.PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []types.PutEventsRequestEntry{{
+			Detail:       aws.String(string(body)),
+			DetailType:   aws.String(eventType),
+			EventBusName: aws.String(bus),
+			Source:       aws.String("github"),
+		}},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed PutEvents API call")
+	}
+
+	if res != nil && res.FailedEntryCount > 0 {
+		var failures []string
+		for _, entry := range res.Entries {
+			if entry.ErrorCode != nil {
+				failures = append(failures, fmt.Sprintf("%s: %s", aws.ToString(entry.ErrorCode), aws.ToString(entry.ErrorMessage)))
+			}
+		}
+		return errors.Errorf("PutEvents failed for %d entries: %s", res.FailedEntryCount, strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+// QueueTarget delivers events to an SQS queue. The queue's URL is recorded in
+// the log as TargetQueue.
+type QueueTarget struct {
+	Queue  CanSendMessage
+	URL    string
+	Logger Logger
+}
+
+// Send produces a single SQS message whose body is the GitHub event's payload.
+// The event type is provided in the message's githubEvent attribute.
+func (t *QueueTarget) Send(ctx context.Context, eventType string, body []byte) error {
+	t.Logger.Set("TargetQueue", t.URL)
+
+	_, err := t.Queue.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(t.URL),
+		MessageBody: aws.String(string(body)),
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			"githubEvent": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(eventType),
+			},
+		},
+	})
+
+	return errors.Wrap(err, "failed SendMessage API call")
+}
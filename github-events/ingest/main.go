@@ -4,10 +4,12 @@ import (
 	"context"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/cloudwatchevents"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/pkg/errors"
 	"github.com/rclark/aws-basics/github-events/ingest/invocation"
 	"github.com/rclark/aws-basics/utils"
@@ -19,11 +21,46 @@ func main() {
 		log.Fatalf("%+v", errors.Wrap(err, "could not acquire AWS credentials"))
 	}
 
+	logger := utils.Logger{}
+
 	handler := &invocation.Handler{
 		Secret: os.Getenv("GITHUB_WEBHOOK_SECRET"),
 		Bus:    os.Getenv("GITHUB_EVENT_BUS_NAME"),
-		Events: cloudwatchevents.NewFromConfig(cfg),
-		Logger: utils.Logger{},
+		Events: eventbridge.NewFromConfig(cfg),
+		Logger: logger,
+		Metrics: utils.Metrics{
+			Namespace: "aws-basics/github-events",
+			Logger:    logger,
+		},
+
+		WrapEnvelope: os.Getenv("GITHUB_EVENT_ENVELOPE") == "true",
+	}
+
+	if os.Getenv("TARGET_TYPE") == "sqs" {
+		handler.Target = &invocation.QueueTarget{
+			Queue:  sqs.NewFromConfig(cfg),
+			URL:    os.Getenv("TARGET_QUEUE_URL"),
+			Logger: logger,
+		}
+	}
+
+	handler.AllowedEvents = utils.SplitList(os.Getenv("GITHUB_ALLOWED_EVENTS"))
+
+	for _, route := range utils.SplitList(os.Getenv("GITHUB_EVENT_BUS_ROUTING")) {
+		eventType, bus, ok := strings.Cut(route, "=")
+		if !ok {
+			log.Fatalf("invalid event bus route %q, expected <event type>=<bus name>", route)
+		}
+
+		if handler.BusRouting == nil {
+			handler.BusRouting = map[string]string{}
+		}
+		handler.BusRouting[strings.ToLower(strings.TrimSpace(eventType))] = strings.TrimSpace(bus)
+	}
+
+	if os.Getenv("API_GATEWAY_TYPE") == "rest" {
+		lambda.Start(handler.RunV1)
+		return
 	}
 
 	lambda.Start(handler.Run)
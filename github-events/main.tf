@@ -23,8 +23,13 @@ resource "aws_lambda_function" "handler" {
 
   environment {
     variables = {
-      "GITHUB_EVENT_BUS_NAME" = aws_cloudwatch_event_bus.bus.name
-      "GITHUB_WEBHOOK_SECRET" = var.webhook-secret
+      "GITHUB_EVENT_BUS_NAME"    = aws_cloudwatch_event_bus.bus.name
+      "GITHUB_WEBHOOK_SECRET"    = var.webhook-secret
+      "GITHUB_ALLOWED_EVENTS"    = join(",", var.allowed-events)
+      "GITHUB_EVENT_BUS_ROUTING" = join(",", [for event, bus in var.bus-routing : "${event}=${bus}"])
+      "GITHUB_EVENT_ENVELOPE"    = tostring(var.wrap-envelope)
+      "TARGET_TYPE"              = var.target-queue-name == "" ? "eventbridge" : "sqs"
+      "TARGET_QUEUE_URL"         = var.target-queue-name == "" ? "" : data.aws_sqs_queue.target[0].url
     }
   }
 }
@@ -45,32 +50,50 @@ resource "aws_cloudwatch_log_group" "logs" {
   retention_in_days = 14
 }
 
+data "aws_sqs_queue" "target" {
+  count = var.target-queue-name == "" ? 0 : 1
+  name  = var.target-queue-name
+}
+
 resource "aws_cloudwatch_event_bus" "bus" {
   name = "github-events"
 }
 
+data "aws_region" "current" {}
+
+data "aws_caller_identity" "current" {}
+
 resource "aws_iam_role_policy" "permissions" {
   name = "github-events"
   role = var.role-name
 
   policy = jsonencode({
     Version = "2012-10-17"
-    Statement = [
+    Statement = concat([
       {
         Action   = "lambda:InvokeFunction"
         Effect   = "Allow"
         Resource = aws_lambda_function.handler.arn
       },
       {
-        Action   = "events:PutEvents"
-        Effect   = "Allow"
-        Resource = aws_cloudwatch_event_bus.bus.arn
+        Action = "events:PutEvents"
+        Effect = "Allow"
+        Resource = concat([aws_cloudwatch_event_bus.bus.arn], [
+          for bus in distinct(values(var.bus-routing)) :
+          "arn:aws:events:${data.aws_region.current.name}:${data.aws_caller_identity.current.account_id}:event-bus/${bus}"
+        ])
       },
       {
         Action   = "logs:*"
         Effect   = "Allow"
         Resource = "${aws_cloudwatch_log_group.logs.arn}*"
       }
-    ]
+      ], var.target-queue-name == "" ? [] : [
+      {
+        Action   = "sqs:SendMessage"
+        Effect   = "Allow"
+        Resource = data.aws_sqs_queue.target[0].arn
+      }
+    ])
   })
 }
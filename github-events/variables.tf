@@ -18,3 +18,23 @@ variable "bundle-version" {
 variable "webhook-secret" {
   type = string
 }
+
+variable "allowed-events" {
+  type    = list(string)
+  default = []
+}
+
+variable "bus-routing" {
+  type    = map(string)
+  default = {}
+}
+
+variable "wrap-envelope" {
+  type    = bool
+  default = false
+}
+
+variable "target-queue-name" {
+  type    = string
+  default = ""
+}
@@ -27,18 +27,23 @@ module "artifacts-buckets" {
 module "github-events" {
   source = "./github-events"
 
-  role-arn       = module.system-permissions.arn
-  role-name      = module.system-permissions.name
-  bucket-name    = module.artifacts-buckets[var.primary-region].name
-  webhook-secret = var.webhook-secret
+  role-arn          = module.system-permissions.arn
+  role-name         = module.system-permissions.name
+  bucket-name       = module.artifacts-buckets[var.primary-region].name
+  webhook-secret    = var.webhook-secret
+  allowed-events    = var.github-events-allowed-events
+  bus-routing       = var.github-events-bus-routing
+  wrap-envelope     = var.github-events-wrap-envelope
+  target-queue-name = var.github-events-target-queue-name
 }
 
 module "github-app" {
   source = "./github-app"
 
-  role-arn    = module.system-permissions.arn
-  role-name   = module.system-permissions.name
-  bucket-name = module.artifacts-buckets[var.primary-region].name
-  region      = var.primary-region
-  account-id  = data.aws_caller_identity.current.account_id
+  role-arn       = module.system-permissions.arn
+  role-name      = module.system-permissions.name
+  bucket-name    = module.artifacts-buckets[var.primary-region].name
+  region         = var.primary-region
+  account-id     = data.aws_caller_identity.current.account_id
+  github-api-url = var.github-api-url
 }
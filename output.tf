@@ -1,3 +1,12 @@
 output "github-events-endpoint" {
   value = module.github-events.endpoint
 }
+
+output "github-app" {
+  value = {
+    id          = var.github-app-id
+    client-id   = var.github-app-client-id
+    slug        = var.github-app-slug
+    install-url = var.github-app-slug == "" ? "" : "${var.github-server-url}/apps/${var.github-app-slug}/installations/new"
+  }
+}
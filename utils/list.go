@@ -0,0 +1,17 @@
+package utils
+
+import "strings"
+
+// SplitList parses a comma-separated list, such as one read from an
+// environment variable. Spaces around each item are trimmed, and empty items
+// are dropped.
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,14 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitList(t *testing.T) {
+	assert.Equal(t, []string{"push", "pull_request"}, SplitList("push,pull_request"), "splits on commas")
+	assert.Equal(t, []string{"push", "pull_request"}, SplitList(" push, pull_request "), "trims spaces")
+	assert.Equal(t, []string{"push"}, SplitList("push,, ,"), "drops empty items")
+	assert.Empty(t, SplitList(""), "empty list")
+}
@@ -7,7 +7,7 @@ import (
 
 // Logger is used to produce one structured JSON log message for each Lambda
 // function invocation.
-type Logger map[string]string
+type Logger map[string]interface{}
 
 // Clear empties anything in the Log, and should be called at the beginning of
 // each Lambda function invocation.
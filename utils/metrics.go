@@ -0,0 +1,52 @@
+package utils
+
+import "time"
+
+// Metrics records counters using CloudWatch's embedded metric format. See
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+// for more information. Counters are written to the Logger, so that the single
+// log entry printed for each Lambda function invocation also publishes the
+// invocation's metrics.
+type Metrics struct {
+	Namespace string
+	Logger    Logger
+}
+
+type metadata struct {
+	Timestamp         int64       `json:"Timestamp"`
+	CloudWatchMetrics []directive `json:"CloudWatchMetrics"`
+}
+
+type directive struct {
+	Namespace  string     `json:"Namespace"`
+	Dimensions [][]string `json:"Dimensions"`
+	Metrics    []metric   `json:"Metrics"`
+}
+
+type metric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+// Count adds the value to the named counter.
+func (m Metrics) Count(name string, value float64) {
+	meta, ok := m.Logger["_aws"].(*metadata)
+	if !ok {
+		meta = &metadata{
+			Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+			CloudWatchMetrics: []directive{{
+				Namespace:  m.Namespace,
+				Dimensions: [][]string{{}},
+			}},
+		}
+		m.Logger["_aws"] = meta
+	}
+
+	if current, ok := m.Logger[name].(float64); ok {
+		m.Logger[name] = current + value
+		return
+	}
+
+	meta.CloudWatchMetrics[0].Metrics = append(meta.CloudWatchMetrics[0].Metrics, metric{Name: name, Unit: "Count"})
+	m.Logger[name] = value
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type emf struct {
+	AWS struct {
+		Timestamp         int64 `json:"Timestamp"`
+		CloudWatchMetrics []struct {
+			Namespace  string     `json:"Namespace"`
+			Dimensions [][]string `json:"Dimensions"`
+			Metrics    []struct {
+				Name string `json:"Name"`
+				Unit string `json:"Unit"`
+			} `json:"Metrics"`
+		} `json:"CloudWatchMetrics"`
+	} `json:"_aws"`
+}
+
+func decode(t *testing.T, logger Logger) (emf, map[string]interface{}) {
+	data, err := json.Marshal(logger)
+	require.NoError(t, err, "failed to serialize log")
+
+	var doc emf
+	require.NoError(t, json.Unmarshal(data, &doc), "failed to parse metadata")
+
+	var values map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &values), "failed to parse values")
+
+	return doc, values
+}
+
+func TestMetricsCount(t *testing.T) {
+	logger := Logger{}
+	metrics := Metrics{Namespace: "aws-basics/test", Logger: logger}
+
+	logger.Set("Delivery", "the-delivery")
+	metrics.Count("Accepted", 1)
+	metrics.Count("Accepted", 2)
+	metrics.Count("SignatureRejected", 1)
+
+	doc, values := decode(t, logger)
+
+	assert.NotZero(t, doc.AWS.Timestamp, "sets a timestamp")
+	require.Len(t, doc.AWS.CloudWatchMetrics, 1, "one metric directive")
+
+	directive := doc.AWS.CloudWatchMetrics[0]
+	assert.Equal(t, "aws-basics/test", directive.Namespace, "uses the namespace")
+	assert.Equal(t, [][]string{{}}, directive.Dimensions, "no dimensions")
+	require.Len(t, directive.Metrics, 2, "declares each metric once")
+	assert.Equal(t, "Accepted", directive.Metrics[0].Name)
+	assert.Equal(t, "Count", directive.Metrics[0].Unit)
+	assert.Equal(t, "SignatureRejected", directive.Metrics[1].Name)
+	assert.Equal(t, "Count", directive.Metrics[1].Unit)
+
+	assert.Equal(t, float64(3), values["Accepted"], "repeated counts add up")
+	assert.Equal(t, float64(1), values["SignatureRejected"], "counts each metric")
+	assert.Equal(t, "the-delivery", values["Delivery"], "shares the log entry")
+}
+
+func TestMetricsClear(t *testing.T) {
+	logger := Logger{}
+	metrics := Metrics{Namespace: "aws-basics/test", Logger: logger}
+
+	metrics.Count("Accepted", 1)
+	logger.Clear()
+
+	_, values := decode(t, logger)
+	assert.Empty(t, values, "clears metrics from the log")
+
+	metrics.Count("PutEventsFailed", 1)
+
+	doc, values := decode(t, logger)
+	require.Len(t, doc.AWS.CloudWatchMetrics, 1, "one metric directive")
+	require.Len(t, doc.AWS.CloudWatchMetrics[0].Metrics, 1, "only declares metrics counted since clearing")
+	assert.Equal(t, "PutEventsFailed", doc.AWS.CloudWatchMetrics[0].Metrics[0].Name)
+	assert.Equal(t, float64(1), values["PutEventsFailed"], "counts from zero")
+	assert.NotContains(t, values, "Accepted", "does not keep cleared counts")
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultMaxRetries is the number of times that an operation is retried when a
+// Retry does not set MaxRetries.
+const DefaultMaxRetries = 2
+
+// Retry repeats operations that may fail temporarily, waiting between each
+// attempt.
+type Retry struct {
+	// MaxRetries is the number of times that an operation is retried after a
+	// failure. Defaults to DefaultMaxRetries, and a negative value disables
+	// retries.
+	MaxRetries int
+
+	// Backoff returns how long to wait before a retry, given the retry's number
+	// starting from 1. Defaults to ExponentialBackoff.
+	Backoff func(retry int) time.Duration
+}
+
+// ExponentialBackoff waits 100ms before the first retry, and twice as long
+// before each retry after that.
+func ExponentialBackoff(retry int) time.Duration {
+	return time.Duration(1<<(retry-1)) * 100 * time.Millisecond
+}
+
+// Do runs the operation, which reports whether it failed in a way that should
+// be retried, along with its error. The operation is repeated until it
+// succeeds, the retries are exhausted, or the context is cancelled. Do returns
+// the number of retries that were made, and the error from the last attempt.
+func (r Retry) Do(ctx context.Context, op func() (bool, error)) (int, error) {
+	max := r.MaxRetries
+	if max == 0 {
+		max = DefaultMaxRetries
+	}
+
+	backoff := r.Backoff
+	if backoff == nil {
+		backoff = ExponentialBackoff
+	}
+
+	for retries := 0; ; retries++ {
+		retry, err := op()
+		if !retry || retries >= max {
+			return retries, err
+		}
+
+		select {
+		case <-time.After(backoff(retries + 1)):
+		case <-ctx.Done():
+			return retries, err
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetrySucceeds(t *testing.T) {
+	var backoffs []int
+	retry := Retry{Backoff: func(retry int) time.Duration {
+		backoffs = append(backoffs, retry)
+		return 0
+	}}
+
+	attempts := 0
+	retries, err := retry.Do(context.Background(), func() (bool, error) {
+		attempts++
+		if attempts < 2 {
+			return true, errors.New("throttled")
+		}
+		return false, nil
+	})
+
+	assert.NoError(t, err, "returns the last attempt's result")
+	assert.Equal(t, 1, retries, "counts retries")
+	assert.Equal(t, []int{1}, backoffs, "backs off before each retry")
+}
+
+func TestRetryExhausted(t *testing.T) {
+	retry := Retry{Backoff: func(int) time.Duration { return 0 }}
+
+	attempts := 0
+	retries, err := retry.Do(context.Background(), func() (bool, error) {
+		attempts++
+		return true, errors.New("throttled")
+	})
+
+	assert.EqualError(t, err, "throttled", "returns the last error")
+	assert.Equal(t, DefaultMaxRetries, retries, "retries up to the default")
+	assert.Equal(t, DefaultMaxRetries+1, attempts, "attempts once more than it retries")
+}
+
+func TestRetryDisabled(t *testing.T) {
+	retry := Retry{MaxRetries: -1}
+
+	attempts := 0
+	retries, err := retry.Do(context.Background(), func() (bool, error) {
+		attempts++
+		return true, errors.New("throttled")
+	})
+
+	assert.EqualError(t, err, "throttled", "returns the error")
+	assert.Equal(t, 0, retries, "does not retry")
+	assert.Equal(t, 1, attempts, "attempts once")
+}
+
+func TestRetryNotRetryable(t *testing.T) {
+	attempts := 0
+	retries, err := Retry{}.Do(context.Background(), func() (bool, error) {
+		attempts++
+		return false, errors.New("bad request")
+	})
+
+	assert.EqualError(t, err, "bad request", "returns the error")
+	assert.Equal(t, 0, retries, "does not retry")
+	assert.Equal(t, 1, attempts, "attempts once")
+}
+
+func TestRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	retry := Retry{Backoff: func(int) time.Duration { return time.Hour }}
+
+	attempts := 0
+	retries, err := retry.Do(ctx, func() (bool, error) {
+		attempts++
+		return true, errors.New("throttled")
+	})
+
+	assert.EqualError(t, err, "throttled", "returns the last error")
+	assert.Equal(t, 0, retries, "stops waiting when cancelled")
+	assert.Equal(t, 1, attempts, "attempts once")
+}
@@ -10,4 +10,49 @@ variable "webhook-secret" {
   type = string
 }
 
+variable "github-events-allowed-events" {
+  type    = list(string)
+  default = []
+}
+
+variable "github-events-bus-routing" {
+  type    = map(string)
+  default = {}
+}
+
+variable "github-events-wrap-envelope" {
+  type    = bool
+  default = false
+}
+
+variable "github-events-target-queue-name" {
+  type    = string
+  default = ""
+}
+
+variable "github-server-url" {
+  type    = string
+  default = "https://github.com"
+}
+
+variable "github-api-url" {
+  type    = string
+  default = "https://api.github.com"
+}
+
+variable "github-app-id" {
+  type    = string
+  default = ""
+}
+
+variable "github-app-client-id" {
+  type    = string
+  default = ""
+}
+
+variable "github-app-slug" {
+  type    = string
+  default = ""
+}
+
 data "aws_caller_identity" "current" {}